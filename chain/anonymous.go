@@ -0,0 +1,55 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"github.com/shrinex/shield/authz"
+)
+
+type (
+	AnonymousConfigurer struct {
+		builder     *Builder
+		name        string
+		roles       []authz.Role
+		authorities []authz.Authority
+	}
+)
+
+var _ Configurer = (*AnonymousConfigurer)(nil)
+
+func (c *AnonymousConfigurer) Principal(name string) *AnonymousConfigurer {
+	c.name = name
+	return c
+}
+
+func (c *AnonymousConfigurer) Roles(roles ...authz.Role) *AnonymousConfigurer {
+	c.roles = append(c.roles, roles...)
+	return c
+}
+
+func (c *AnonymousConfigurer) Authorities(authorities ...authz.Authority) *AnonymousConfigurer {
+	c.authorities = append(c.authorities, authorities...)
+	return c
+}
+
+func (c *AnonymousConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order places the anonymous principal after every
+// authentication, and before authorization
+func (c *AnonymousConfigurer) Order() int {
+	return 25
+}
+
+func (c *AnonymousConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAnonymousMiddleware(
+			builder.subject,
+			middlewares.WithAnonymousPrincipal(c.name),
+			middlewares.WithAnonymousRoles(c.roles...),
+			middlewares.WithAnonymousAuthorities(c.authorities...),
+		).Handle)
+}
@@ -0,0 +1,82 @@
+package chain
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/middlewares"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/codec"
+	"github.com/shrinex/shield/security"
+	"github.com/shrinex/shield/semgt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type (
+	user string
+
+	// realm accepts any password of the known users
+	realm map[string]bool
+)
+
+func (u user) Principal() string {
+	return string(u)
+}
+
+func (r realm) Supports(token authc.Token) bool {
+	_, ok := token.(*authc.UsernamePasswordToken)
+	return ok
+}
+
+func (r realm) LoadUserDetails(_ context.Context, token authc.Token) (authc.UserDetails, error) {
+	if !r[token.Principal()] {
+		return nil, authc.ErrUnauthenticated
+	}
+	return user(token.Principal()), nil
+}
+
+func TestAnonymousChain(t *testing.T) {
+	repository := semgt.NewRepository(codec.JSON, time.Hour, time.Hour)
+	subject := security.NewBuilder[*semgt.MapSession]().
+		Authenticator(authc.NewAuthenticator(realm{"archer": true})).
+		Authorizer(authz.NoopAuthorizer).
+		Repository(repository).
+		Registry(semgt.NewRegistry(repository)).
+		Build()
+
+	chain := NewBuilder().
+		Subject().Use(subject).And().
+		AuthorizeRequests().
+		AntMatches("/catalog/**").HasRole(authz.NewRole(middlewares.DefaultAnonymousRole)).
+		AnyRequests().Authenticated().And().
+		Anonymous().Principal("guest").And().
+		Build()
+
+	var principal string
+	handler := chain(func(w http.ResponseWriter, r *http.Request) {
+		user, err := middlewares.NewPrincipalSubject(subject).UserDetails(r.Context())
+		assert.NoError(t, err)
+		principal = user.Principal()
+	})
+
+	serve := func(ctx context.Context, path string) int {
+		principal = ""
+		r := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// the anonymous principal is installed before authorization
+	assert.Equal(t, http.StatusOK, serve(context.Background(), "/catalog/items"))
+	assert.Equal(t, "guest", principal)
+	assert.Equal(t, http.StatusForbidden, serve(context.Background(), "/orders"))
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(ctx, "/orders"))
+	assert.Equal(t, "archer", principal)
+}
@@ -0,0 +1,83 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"net/http"
+)
+
+type (
+	APIKeyConfigurer struct {
+		builder  *Builder
+		store    middlewares.APIKeyStore
+		resolver middlewares.TokenResolver
+		includes []string
+		excludes []string
+		matcher  ant.Matcher
+		handler  func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*APIKeyConfigurer)(nil)
+
+func (c *APIKeyConfigurer) Store(store middlewares.APIKeyStore) *APIKeyConfigurer {
+	c.store = store
+	return c
+}
+
+func (c *APIKeyConfigurer) Header(name string) *APIKeyConfigurer {
+	c.resolver = middlewares.NewHeaderTokenResolver(name, "")
+	return c
+}
+
+func (c *APIKeyConfigurer) AntMatches(patterns ...string) *APIKeyConfigurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *APIKeyConfigurer) AnyRequests() *APIKeyConfigurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *APIKeyConfigurer) AntExcludes(patterns ...string) *APIKeyConfigurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *APIKeyConfigurer) Use(matcher ant.Matcher) *APIKeyConfigurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *APIKeyConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *APIKeyConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *APIKeyConfigurer) And() *Builder {
+	return c.builder
+}
+
+func (c *APIKeyConfigurer) Order() int {
+	return 10
+}
+
+func (c *APIKeyConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.store == nil {
+		panic("call APIKeyConfigurer.Store() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanism(middlewares.NewAPIKeyMechanism(c.store, c.resolver)),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"github.com/shrinex/shield-web/jwt"
 	"github.com/shrinex/shield-web/middlewares"
 	ant "github.com/shrinex/shield-web/pattern"
 	"net/http"
@@ -8,11 +9,17 @@ import (
 
 type (
 	AuthcConfigurer struct {
-		builder  *Builder
-		includes []string
-		excludes []string
-		matcher  ant.Matcher
-		handler  func(http.ResponseWriter, *http.Request, error)
+		builder           *Builder
+		includes          []string
+		excludes          []string
+		matcher           ant.Matcher
+		resolver          middlewares.TokenResolver
+		verifier          *jwt.Verifier
+		jwtOpts           []middlewares.JWTOption
+		introspector      *middlewares.Introspector
+		introspectionOpts []middlewares.IntrospectionOption
+		throttler         *middlewares.Throttler
+		handler           func(http.ResponseWriter, *http.Request, error)
 	}
 )
 
@@ -38,6 +45,32 @@ func (c *AuthcConfigurer) Use(matcher ant.Matcher) *AuthcConfigurer {
 	return c
 }
 
+func (c *AuthcConfigurer) ResolveTokenWith(resolvers ...middlewares.TokenResolver) *AuthcConfigurer {
+	c.resolver = resolverOf(resolvers)
+	return c
+}
+
+// JWT switches to the stateless mode, where bearer tokens are
+// validated locally as JWTs instead of calling Subject.Login
+func (c *AuthcConfigurer) JWT(verifier *jwt.Verifier, opts ...middlewares.JWTOption) *AuthcConfigurer {
+	c.verifier = verifier
+	c.jwtOpts = append(c.jwtOpts, opts...)
+	return c
+}
+
+// Introspect switches to the resource server mode, where opaque bearer
+// tokens are validated by the authorization server via RFC 7662
+func (c *AuthcConfigurer) Introspect(introspector *middlewares.Introspector, opts ...middlewares.IntrospectionOption) *AuthcConfigurer {
+	c.introspector = introspector
+	c.introspectionOpts = append(c.introspectionOpts, opts...)
+	return c
+}
+
+func (c *AuthcConfigurer) Throttle(throttler *middlewares.Throttler) *AuthcConfigurer {
+	c.throttler = throttler
+	return c
+}
+
 func (c *AuthcConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *AuthcConfigurer {
 	c.handler = handler
 	return c
@@ -55,12 +88,23 @@ func (c *AuthcConfigurer) Configure(builder *Builder) {
 	if builder.subject == nil {
 		panic("call Builder.Subject() first")
 	}
+	opts := []middlewares.AuthcOption{
+		middlewares.WithMatcher(c.matcher),
+		middlewares.WithTokenResolver(c.resolver),
+		middlewares.WithPatterns(c.includes...),
+		middlewares.WithExcludePatterns(c.excludes...),
+		middlewares.WithThrottler(c.throttler),
+		middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+		middlewares.WithAuthcEvents(builder.events),
+	}
+	if c.verifier != nil {
+		opts = append(opts, middlewares.WithMechanism(middlewares.NewJWTMechanism(c.verifier,
+			append([]middlewares.JWTOption{middlewares.WithJWTTokenResolver(c.resolver)}, c.jwtOpts...)...)))
+	}
+	if c.introspector != nil {
+		opts = append(opts, middlewares.WithMechanism(middlewares.NewIntrospectionMechanism(c.introspector,
+			append([]middlewares.IntrospectionOption{middlewares.WithIntrospectionTokenResolver(c.resolver)}, c.introspectionOpts...)...)))
+	}
 	builder.chain = append(builder.chain,
-		middlewares.NewAuthcMiddleware(
-			builder.subject,
-			middlewares.WithMatcher(c.matcher),
-			middlewares.WithPatterns(c.includes...),
-			middlewares.WithExcludePatterns(c.excludes...),
-			middlewares.WithUnauthorizedHandler(c.handler),
-		).Handle)
+		middlewares.NewAuthcMiddleware(builder.subject, opts...).Handle)
 }
@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"net"
+	"net/http"
+)
+
+type (
+	// AuthenticationConfigurer installs a single AuthcMiddleware that
+	// tries several mechanisms in the order they are registered
+	AuthenticationConfigurer struct {
+		builder    *Builder
+		mechanisms []middlewares.Mechanism
+		includes   []string
+		excludes   []string
+		matcher    ant.Matcher
+		throttler  *middlewares.Throttler
+		handler    func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*AuthenticationConfigurer)(nil)
+
+// Bearer registers the bearer mechanism, the token is read
+// from `Authorization: Bearer` if no resolvers specified
+func (c *AuthenticationConfigurer) Bearer(resolvers ...middlewares.TokenResolver) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewBearerMechanism(resolverOf(resolvers)))
+}
+
+// JWT registers the stateless JWT mechanism
+func (c *AuthenticationConfigurer) JWT(verifier *jwt.Verifier, opts ...middlewares.JWTOption) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewJWTMechanism(verifier, opts...))
+}
+
+// Introspection registers the RFC 7662 token introspection mechanism
+func (c *AuthenticationConfigurer) Introspection(introspector *middlewares.Introspector, opts ...middlewares.IntrospectionOption) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewIntrospectionMechanism(introspector, opts...))
+}
+
+// Basic registers the HTTP Basic mechanism, which verifies
+// credentials through the specified authenticator
+func (c *AuthenticationConfigurer) Basic(realm string, authenticator authc.Authenticator, opts ...middlewares.BasicOption) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewBasicMechanism(realm, authenticator, opts...))
+}
+
+// APIKey registers the API key mechanism, the key is
+// read from X-API-Key if no resolvers specified
+func (c *AuthenticationConfigurer) APIKey(store middlewares.APIKeyStore, resolvers ...middlewares.TokenResolver) *AuthenticationConfigurer {
+	var resolver middlewares.TokenResolver
+	if len(resolvers) > 0 {
+		resolver = resolverOf(resolvers)
+	}
+	return c.Mechanism(middlewares.NewAPIKeyMechanism(store, resolver))
+}
+
+// X509 registers the client certificate mechanism
+func (c *AuthenticationConfigurer) X509(opts ...middlewares.X509Option) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewX509Mechanism(opts...))
+}
+
+// Proxy registers the trusted proxy mechanism, identity headers from
+// untrusted sources are rejected before any mechanism is tried
+func (c *AuthenticationConfigurer) Proxy(trusted []*net.IPNet, opts ...middlewares.ProxyOption) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewProxyMechanism(trusted, opts...))
+}
+
+// Signature registers the HMAC request signing mechanism
+func (c *AuthenticationConfigurer) Signature(store middlewares.SigningKeyStore, opts ...middlewares.SignatureOption) *AuthenticationConfigurer {
+	return c.Mechanism(middlewares.NewSignatureMechanism(store, opts...))
+}
+
+// Mechanism registers a custom mechanism
+func (c *AuthenticationConfigurer) Mechanism(mechanism middlewares.Mechanism) *AuthenticationConfigurer {
+	c.mechanisms = append(c.mechanisms, mechanism)
+	return c
+}
+
+func (c *AuthenticationConfigurer) AntMatches(patterns ...string) *AuthenticationConfigurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *AuthenticationConfigurer) AnyRequests() *AuthenticationConfigurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *AuthenticationConfigurer) AntExcludes(patterns ...string) *AuthenticationConfigurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *AuthenticationConfigurer) Use(matcher ant.Matcher) *AuthenticationConfigurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *AuthenticationConfigurer) Throttle(throttler *middlewares.Throttler) *AuthenticationConfigurer {
+	c.throttler = throttler
+	return c
+}
+
+func (c *AuthenticationConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *AuthenticationConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *AuthenticationConfigurer) And() *Builder {
+	return c.builder
+}
+
+func (c *AuthenticationConfigurer) Order() int {
+	return 10
+}
+
+func (c *AuthenticationConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if len(c.mechanisms) == 0 {
+		panic("register at least one mechanism")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanisms(c.mechanisms...),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithThrottler(c.throttler),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
+
+func resolverOf(resolvers []middlewares.TokenResolver) middlewares.TokenResolver {
+	switch len(resolvers) {
+	case 0:
+		return middlewares.NewBearerTokenResolver()
+	case 1:
+		return resolvers[0]
+	default:
+		return middlewares.NewCompositeTokenResolver(resolvers...)
+	}
+}
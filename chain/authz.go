@@ -6,14 +6,17 @@ import (
 	"github.com/shrinex/shield/authz"
 	"github.com/shrinex/shield/security"
 	"net/http"
+	"time"
 )
 
 type (
 	AuthzConfigurer struct {
-		builder  *Builder
-		registry *ant.RouteRegistry
-		mode     middlewares.AuthzMode
-		handler  func(http.ResponseWriter, *http.Request)
+		builder    *Builder
+		registry   *ant.RouteRegistry
+		source     middlewares.PolicySource
+		mode       middlewares.AuthzMode
+		handler    func(http.ResponseWriter, *http.Request)
+		mfaHandler func(http.ResponseWriter, *http.Request)
 	}
 )
 
@@ -29,6 +32,18 @@ func (c *AuthzConfigurer) Use(registry *ant.RouteRegistry) *AuthzConfigurer {
 	return c
 }
 
+// Source evaluates the registry provided by the source instead, which may
+// change while requests are being served, e.g. middlewares.PolicyFileWatcher
+func (c *AuthzConfigurer) Source(source middlewares.PolicySource) *AuthzConfigurer {
+	if len(c.registry.Mappings) > 0 ||
+		len(c.registry.Includes) > 0 ||
+		len(c.registry.Excludes) > 0 {
+		panic("call AuthzConfigurer.Source() first")
+	}
+	c.source = source
+	return c
+}
+
 func (c *AuthzConfigurer) RouteMatches(method string, patterns ...string) *AuthzConfigurer {
 	c.registry.RouteMatches(method, patterns...)
 	return c
@@ -59,6 +74,12 @@ func (c *AuthzConfigurer) That(predicate ant.Predicate) *AuthzConfigurer {
 	return c
 }
 
+// Access permits requests satisfying the expression, see pattern.CompileExpression
+func (c *AuthzConfigurer) Access(expression string) *AuthzConfigurer {
+	c.registry.Access(expression)
+	return c
+}
+
 func (c *AuthzConfigurer) DenyAll() *AuthzConfigurer {
 	c.registry.DenyAll()
 	return c
@@ -134,6 +155,16 @@ func (c *AuthzConfigurer) HasAllAuthorityFunc(fn func(*http.Request, security.Su
 	return c
 }
 
+func (c *AuthzConfigurer) PathVariableIsPrincipal(name string) *AuthzConfigurer {
+	c.registry.PathVariableIsPrincipal(name)
+	return c
+}
+
+func (c *AuthzConfigurer) RequiresRecentMFA(maxAge time.Duration) *AuthzConfigurer {
+	c.registry.RequiresRecentMFA(maxAge)
+	return c
+}
+
 func (c *AuthzConfigurer) UnanimousMode() *AuthzConfigurer {
 	c.mode = middlewares.Unanimous
 	return c
@@ -149,6 +180,11 @@ func (c *AuthzConfigurer) WhenForbidden(handler func(http.ResponseWriter, *http.
 	return c
 }
 
+func (c *AuthzConfigurer) WhenMFARequired(handler func(http.ResponseWriter, *http.Request)) *AuthzConfigurer {
+	c.mfaHandler = handler
+	return c
+}
+
 func (c *AuthzConfigurer) And() *Builder {
 	return c.builder
 }
@@ -161,11 +197,18 @@ func (c *AuthzConfigurer) Configure(builder *Builder) {
 	if builder.subject == nil {
 		panic("call Builder.Subject() first")
 	}
+	source := c.source
+	if source == nil {
+		source = middlewares.NewAtomicPolicySource(c.registry)
+	} else if len(c.registry.Mappings) > 0 {
+		panic("rules are provided by AuthzConfigurer.Source()")
+	}
 	builder.chain = append(builder.chain,
 		middlewares.NewAuthzMiddleware(
 			builder.subject,
 			middlewares.WithAuthzMode(c.mode),
-			middlewares.WithRouteRegistry(c.registry),
+			middlewares.WithPolicySource(source),
 			middlewares.WithForbiddenHandler(c.handler),
+			middlewares.WithMFARequiredHandler(c.mfaHandler),
 		).Handle)
 }
@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"net/http"
+)
+
+type (
+	BasicAuthConfigurer struct {
+		builder       *Builder
+		realm         string
+		authenticator authc.Authenticator
+		authorization authz.Realm
+		includes      []string
+		excludes      []string
+		matcher       ant.Matcher
+		handler       func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*BasicAuthConfigurer)(nil)
+
+func (c *BasicAuthConfigurer) Realm(realm string) *BasicAuthConfigurer {
+	c.realm = realm
+	return c
+}
+
+// Authenticator verifies the username and password, usually
+// the same one the Subject is built with
+func (c *BasicAuthConfigurer) Authenticator(authenticator authc.Authenticator) *BasicAuthConfigurer {
+	c.authenticator = authenticator
+	return c
+}
+
+// Authorization loads the roles and authorities of the user,
+// see middlewares.WithBasicAuthorization
+func (c *BasicAuthConfigurer) Authorization(realm authz.Realm) *BasicAuthConfigurer {
+	c.authorization = realm
+	return c
+}
+
+func (c *BasicAuthConfigurer) AntMatches(patterns ...string) *BasicAuthConfigurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *BasicAuthConfigurer) AnyRequests() *BasicAuthConfigurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *BasicAuthConfigurer) AntExcludes(patterns ...string) *BasicAuthConfigurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *BasicAuthConfigurer) Use(matcher ant.Matcher) *BasicAuthConfigurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *BasicAuthConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *BasicAuthConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *BasicAuthConfigurer) And() *Builder {
+	return c.builder
+}
+
+func (c *BasicAuthConfigurer) Order() int {
+	return 10
+}
+
+func (c *BasicAuthConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.authenticator == nil {
+		panic("call BasicAuthConfigurer.Authenticator() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanism(middlewares.NewBasicMechanism(
+				c.realm,
+				c.authenticator,
+				middlewares.WithBasicAuthorization(c.authorization),
+			)),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
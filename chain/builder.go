@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"github.com/shrinex/shield-web/middlewares"
 	ant "github.com/shrinex/shield-web/pattern"
 	"github.com/shrinex/shield/security"
 	"net/http"
@@ -13,9 +14,12 @@ type (
 	Configurer = SecurityConfigurer[Middleware, *Builder]
 
 	Builder struct {
-		subject security.Subject
-		chain   []Middleware
-		cfgs    []Configurer
+		subject       security.Subject
+		events        *middlewares.EventPublisher
+		entryPoint    middlewares.EntryPoint
+		fingerprinter middlewares.Fingerprinter
+		chain         []Middleware
+		cfgs          []Configurer
 	}
 )
 
@@ -30,6 +34,14 @@ func (b *Builder) Subject() *SubjectConfigurer {
 	return b.apply(&SubjectConfigurer{builder: b}).(*SubjectConfigurer)
 }
 
+func (b *Builder) Events() *EventsConfigurer {
+	return b.apply(&EventsConfigurer{builder: b}).(*EventsConfigurer)
+}
+
+func (b *Builder) EntryPoint() *EntryPointConfigurer {
+	return b.apply(&EntryPointConfigurer{builder: b}).(*EntryPointConfigurer)
+}
+
 func (b *Builder) BearerAuth() *AuthcConfigurer {
 	return b.apply(&AuthcConfigurer{
 		builder: b,
@@ -37,6 +49,76 @@ func (b *Builder) BearerAuth() *AuthcConfigurer {
 	}).(*AuthcConfigurer)
 }
 
+func (b *Builder) BasicAuth() *BasicAuthConfigurer {
+	return b.apply(&BasicAuthConfigurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*BasicAuthConfigurer)
+}
+
+func (b *Builder) APIKeyAuth() *APIKeyConfigurer {
+	return b.apply(&APIKeyConfigurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*APIKeyConfigurer)
+}
+
+func (b *Builder) X509() *X509Configurer {
+	return b.apply(&X509Configurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*X509Configurer)
+}
+
+func (b *Builder) PreAuthenticated() *PreAuthenticatedConfigurer {
+	return b.apply(&PreAuthenticatedConfigurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*PreAuthenticatedConfigurer)
+}
+
+func (b *Builder) Signature() *SignatureConfigurer {
+	return b.apply(&SignatureConfigurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*SignatureConfigurer)
+}
+
+func (b *Builder) Authentication() *AuthenticationConfigurer {
+	return b.apply(&AuthenticationConfigurer{
+		builder: b,
+		matcher: ant.NewMatcher(),
+	}).(*AuthenticationConfigurer)
+}
+
+func (b *Builder) FormLogin() *FormLoginConfigurer {
+	return b.apply(&FormLoginConfigurer{builder: b}).(*FormLoginConfigurer)
+}
+
+func (b *Builder) OIDCLogin() *OIDCLoginConfigurer {
+	return b.apply(&OIDCLoginConfigurer{builder: b}).(*OIDCLoginConfigurer)
+}
+
+func (b *Builder) Impersonation() *ImpersonationConfigurer {
+	return b.apply(&ImpersonationConfigurer{builder: b}).(*ImpersonationConfigurer)
+}
+
+func (b *Builder) MFA() *MFAConfigurer {
+	return b.apply(&MFAConfigurer{builder: b}).(*MFAConfigurer)
+}
+
+func (b *Builder) Logout() *LogoutConfigurer {
+	return b.apply(&LogoutConfigurer{builder: b}).(*LogoutConfigurer)
+}
+
+func (b *Builder) RefreshToken() *RefreshTokenConfigurer {
+	return b.apply(&RefreshTokenConfigurer{builder: b}).(*RefreshTokenConfigurer)
+}
+
+func (b *Builder) Anonymous() *AnonymousConfigurer {
+	return b.apply(&AnonymousConfigurer{builder: b}).(*AnonymousConfigurer)
+}
+
 func (b *Builder) AuthorizeRequests() *AuthzConfigurer {
 	return b.apply(&AuthzConfigurer{
 		builder:  b,
@@ -64,6 +146,16 @@ func (b *Builder) Build() Middleware {
 	}
 }
 
+// unauthorizedHandler returns the specified handler, or the
+// entry point of the builder if none, which may be nil
+func (b *Builder) unauthorizedHandler(handler func(http.ResponseWriter, *http.Request, error)) func(http.ResponseWriter, *http.Request, error) {
+	if handler != nil || b.entryPoint == nil {
+		return handler
+	}
+
+	return b.entryPoint
+}
+
 func (b *Builder) apply(configurer Configurer) Configurer {
 	b.cfgs = append(b.cfgs, configurer)
 	return configurer
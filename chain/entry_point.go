@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+)
+
+type (
+	// EntryPointConfigurer configures how requests failed to authenticate
+	// are answered by the middlewares of the chain, unless they specify
+	// an unauthorized handler of their own
+	EntryPointConfigurer struct {
+		builder *Builder
+		opts    []middlewares.EntryPointOption
+	}
+)
+
+var _ Configurer = (*EntryPointConfigurer)(nil)
+
+// LoginPage redirects browsers to the specified login page
+func (c *EntryPointConfigurer) LoginPage(loginURL string) *EntryPointConfigurer {
+	c.opts = append(c.opts, middlewares.WithLoginPage(loginURL))
+	return c
+}
+
+func (c *EntryPointConfigurer) ForBrowsers(entryPoint middlewares.EntryPoint) *EntryPointConfigurer {
+	c.opts = append(c.opts, middlewares.WithBrowserEntryPoint(entryPoint))
+	return c
+}
+
+func (c *EntryPointConfigurer) ForAPIs(entryPoint middlewares.EntryPoint) *EntryPointConfigurer {
+	c.opts = append(c.opts, middlewares.WithAPIEntryPoint(entryPoint))
+	return c
+}
+
+// ProblemDetails answers API clients with application/problem+json
+// even if they do not ask for it
+func (c *EntryPointConfigurer) ProblemDetails() *EntryPointConfigurer {
+	return c.ForAPIs(middlewares.ProblemEntryPoint)
+}
+
+// AntMatches uses the specified EntryPoint for paths matching
+// any of the patterns regardless of the client
+func (c *EntryPointConfigurer) AntMatches(entryPoint middlewares.EntryPoint, patterns ...string) *EntryPointConfigurer {
+	for _, pattern := range patterns {
+		c.opts = append(c.opts, middlewares.WithPatternEntryPoint(pattern, entryPoint))
+	}
+	return c
+}
+
+func (c *EntryPointConfigurer) Use(matcher ant.Matcher) *EntryPointConfigurer {
+	c.opts = append(c.opts, middlewares.WithEntryPointMatcher(matcher))
+	return c
+}
+
+func (c *EntryPointConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the entry point is
+// ready before other configurers
+func (c *EntryPointConfigurer) Order() int {
+	return -1
+}
+
+func (c *EntryPointConfigurer) Configure(builder *Builder) {
+	builder.entryPoint = middlewares.NewEntryPoint(c.opts...)
+}
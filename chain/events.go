@@ -0,0 +1,57 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+)
+
+type (
+	// EventsConfigurer registers listeners of the authentication
+	// events published by the middlewares of the chain
+	EventsConfigurer struct {
+		builder   *Builder
+		buffer    int
+		listeners []middlewares.AuthEventListener
+		async     []middlewares.AuthEventListener
+	}
+)
+
+var _ Configurer = (*EventsConfigurer)(nil)
+
+// Listen registers a listener called on the request goroutine
+func (c *EventsConfigurer) Listen(listener middlewares.AuthEventListener) *EventsConfigurer {
+	c.listeners = append(c.listeners, listener)
+	return c
+}
+
+// ListenAsync registers a listener called on a goroutine of its own
+func (c *EventsConfigurer) ListenAsync(listener middlewares.AuthEventListener) *EventsConfigurer {
+	c.async = append(c.async, listener)
+	return c
+}
+
+// Buffer specifies the buffer size of every async listener
+func (c *EventsConfigurer) Buffer(size int) *EventsConfigurer {
+	c.buffer = size
+	return c
+}
+
+func (c *EventsConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the publisher is
+// ready before other configurers
+func (c *EventsConfigurer) Order() int {
+	return -1
+}
+
+func (c *EventsConfigurer) Configure(builder *Builder) {
+	events := middlewares.NewEventPublisher(middlewares.WithEventBuffer(c.buffer))
+	for _, listener := range c.listeners {
+		events.Subscribe(listener)
+	}
+	for _, listener := range c.async {
+		events.SubscribeAsync(listener)
+	}
+	builder.events = events
+}
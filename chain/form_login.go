@@ -0,0 +1,100 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"github.com/shrinex/shield/security"
+	"net/http"
+)
+
+type (
+	FormLoginConfigurer struct {
+		builder           *Builder
+		path              string
+		usernameParameter string
+		passwordParameter string
+		platformParameter string
+		loginOpts         []security.LoginOption
+		refreshTokens     *middlewares.RefreshTokenService
+		throttler         *middlewares.Throttler
+		successHandler    func(http.ResponseWriter, *http.Request)
+		failureHandler    func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*FormLoginConfigurer)(nil)
+
+func (c *FormLoginConfigurer) LoginPath(path string) *FormLoginConfigurer {
+	c.path = path
+	return c
+}
+
+func (c *FormLoginConfigurer) UsernameParameter(name string) *FormLoginConfigurer {
+	c.usernameParameter = name
+	return c
+}
+
+func (c *FormLoginConfigurer) PasswordParameter(name string) *FormLoginConfigurer {
+	c.passwordParameter = name
+	return c
+}
+
+func (c *FormLoginConfigurer) PlatformParameter(name string) *FormLoginConfigurer {
+	c.platformParameter = name
+	return c
+}
+
+func (c *FormLoginConfigurer) LoginOptions(opts ...security.LoginOption) *FormLoginConfigurer {
+	c.loginOpts = append(c.loginOpts, opts...)
+	return c
+}
+
+func (c *FormLoginConfigurer) IssueRefreshTokens(service *middlewares.RefreshTokenService) *FormLoginConfigurer {
+	c.refreshTokens = service
+	return c
+}
+
+func (c *FormLoginConfigurer) Throttle(throttler *middlewares.Throttler) *FormLoginConfigurer {
+	c.throttler = throttler
+	return c
+}
+
+func (c *FormLoginConfigurer) WhenSucceeded(handler func(http.ResponseWriter, *http.Request)) *FormLoginConfigurer {
+	c.successHandler = handler
+	return c
+}
+
+func (c *FormLoginConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *FormLoginConfigurer {
+	c.failureHandler = handler
+	return c
+}
+
+func (c *FormLoginConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the login endpoint is
+// reachable without being authenticated
+func (c *FormLoginConfigurer) Order() int {
+	return 5
+}
+
+func (c *FormLoginConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewFormLoginMiddleware(
+			builder.subject,
+			middlewares.WithLoginPath(c.path),
+			middlewares.WithUsernameParameter(c.usernameParameter),
+			middlewares.WithPasswordParameter(c.passwordParameter),
+			middlewares.WithPlatformParameter(c.platformParameter),
+			middlewares.WithLoginOptions(c.loginOpts...),
+			middlewares.WithRefreshTokens(c.refreshTokens),
+			middlewares.WithLoginThrottler(c.throttler),
+			middlewares.WithLoginFingerprint(builder.fingerprinter),
+			middlewares.WithLoginSuccessHandler(c.successHandler),
+			middlewares.WithLoginFailureHandler(c.failureHandler),
+			middlewares.WithLoginEvents(builder.events),
+		).Handle)
+}
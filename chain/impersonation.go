@@ -0,0 +1,121 @@
+package chain
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/middlewares"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"net/http"
+)
+
+type (
+	ImpersonationConfigurer struct {
+		builder              *Builder
+		authenticator        authc.Authenticator
+		authorization        authz.Realm
+		authority            authz.Authority
+		path                 string
+		exitPath             string
+		parameter            string
+		protectedRoles       []authz.Role
+		protectedAuthorities []authz.Authority
+		listeners            []func(context.Context, *middlewares.ImpersonationEvent)
+		successHandler       func(http.ResponseWriter, *http.Request)
+		failureHandler       func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*ImpersonationConfigurer)(nil)
+
+// Authority specifies the authority required to impersonate others
+func (c *ImpersonationConfigurer) Authority(authority authz.Authority) *ImpersonationConfigurer {
+	c.authority = authority
+	return c
+}
+
+// Targets looks up the users to impersonate, usually through
+// the authenticator and the realm the Subject is built with
+func (c *ImpersonationConfigurer) Targets(authenticator authc.Authenticator, authorization authz.Realm) *ImpersonationConfigurer {
+	c.authenticator = authenticator
+	c.authorization = authorization
+	return c
+}
+
+func (c *ImpersonationConfigurer) SwitchPath(path string) *ImpersonationConfigurer {
+	c.path = path
+	return c
+}
+
+func (c *ImpersonationConfigurer) ExitPath(path string) *ImpersonationConfigurer {
+	c.exitPath = path
+	return c
+}
+
+func (c *ImpersonationConfigurer) UsernameParameter(name string) *ImpersonationConfigurer {
+	c.parameter = name
+	return c
+}
+
+func (c *ImpersonationConfigurer) ProtectRoles(roles ...authz.Role) *ImpersonationConfigurer {
+	c.protectedRoles = append(c.protectedRoles, roles...)
+	return c
+}
+
+func (c *ImpersonationConfigurer) ProtectAuthorities(authorities ...authz.Authority) *ImpersonationConfigurer {
+	c.protectedAuthorities = append(c.protectedAuthorities, authorities...)
+	return c
+}
+
+func (c *ImpersonationConfigurer) OnEvent(listener func(context.Context, *middlewares.ImpersonationEvent)) *ImpersonationConfigurer {
+	c.listeners = append(c.listeners, listener)
+	return c
+}
+
+func (c *ImpersonationConfigurer) WhenSucceeded(handler func(http.ResponseWriter, *http.Request)) *ImpersonationConfigurer {
+	c.successHandler = handler
+	return c
+}
+
+func (c *ImpersonationConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *ImpersonationConfigurer {
+	c.failureHandler = handler
+	return c
+}
+
+func (c *ImpersonationConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the impersonator
+// has been authenticated
+func (c *ImpersonationConfigurer) Order() int {
+	return 12
+}
+
+func (c *ImpersonationConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.authority == nil {
+		panic("call ImpersonationConfigurer.Authority() first")
+	}
+	if c.authenticator == nil || c.authorization == nil {
+		panic("call ImpersonationConfigurer.Targets() first")
+	}
+
+	opts := []middlewares.ImpersonationOption{
+		middlewares.WithImpersonationPath(c.path),
+		middlewares.WithImpersonationExitPath(c.exitPath),
+		middlewares.WithImpersonationParameter(c.parameter),
+		middlewares.WithProtectedRoles(c.protectedRoles...),
+		middlewares.WithProtectedAuthorities(c.protectedAuthorities...),
+		middlewares.WithImpersonationFingerprint(builder.fingerprinter),
+		middlewares.WithImpersonationSuccessHandler(c.successHandler),
+		middlewares.WithImpersonationFailureHandler(c.failureHandler),
+	}
+	for _, listener := range c.listeners {
+		opts = append(opts, middlewares.WithImpersonationListener(listener))
+	}
+
+	builder.chain = append(builder.chain,
+		middlewares.NewImpersonationMiddleware(builder.subject, c.authenticator, c.authorization, c.authority, opts...).Handle)
+}
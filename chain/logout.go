@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"net/http"
+)
+
+type (
+	LogoutConfigurer struct {
+		builder  *Builder
+		path     string
+		method   string
+		cookies  []string
+		handlers []middlewares.LogoutHandler
+		handler  func(http.ResponseWriter, *http.Request)
+		failure  func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*LogoutConfigurer)(nil)
+
+func (c *LogoutConfigurer) LogoutPath(path string) *LogoutConfigurer {
+	c.path = path
+	return c
+}
+
+func (c *LogoutConfigurer) LogoutMethod(method string) *LogoutConfigurer {
+	c.method = method
+	return c
+}
+
+func (c *LogoutConfigurer) ClearCookies(names ...string) *LogoutConfigurer {
+	c.cookies = append(c.cookies, names...)
+	return c
+}
+
+func (c *LogoutConfigurer) AddHandler(handler middlewares.LogoutHandler) *LogoutConfigurer {
+	c.handlers = append(c.handlers, handler)
+	return c
+}
+
+func (c *LogoutConfigurer) WhenSucceeded(handler func(http.ResponseWriter, *http.Request)) *LogoutConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *LogoutConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *LogoutConfigurer {
+	c.failure = handler
+	return c
+}
+
+func (c *LogoutConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order places the logout endpoint right after authentication,
+// so that the current session is known but not flushed again
+func (c *LogoutConfigurer) Order() int {
+	return 15
+}
+
+func (c *LogoutConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewLogoutMiddleware(
+			builder.subject,
+			middlewares.WithLogoutPath(c.path),
+			middlewares.WithLogoutMethod(c.method),
+			middlewares.WithClearCookies(c.cookies...),
+			middlewares.WithLogoutHandlers(c.handlers...),
+			middlewares.WithLogoutSuccessHandler(c.handler),
+			middlewares.WithLogoutFailureHandler(c.failure),
+			middlewares.WithLogoutEvents(builder.events),
+		).Handle)
+}
@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"net/http"
+	"time"
+)
+
+type (
+	MFAConfigurer struct {
+		builder        *Builder
+		store          middlewares.MFAStore
+		totp           *middlewares.TOTP
+		issuer         string
+		enrollPath     string
+		verifyPath     string
+		parameter      string
+		reenrollAge    time.Duration
+		throttler      *middlewares.Throttler
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*MFAConfigurer)(nil)
+
+func (c *MFAConfigurer) Store(store middlewares.MFAStore) *MFAConfigurer {
+	c.store = store
+	return c
+}
+
+func (c *MFAConfigurer) TOTP(totp *middlewares.TOTP) *MFAConfigurer {
+	c.totp = totp
+	return c
+}
+
+func (c *MFAConfigurer) Issuer(issuer string) *MFAConfigurer {
+	c.issuer = issuer
+	return c
+}
+
+func (c *MFAConfigurer) EnrollPath(path string) *MFAConfigurer {
+	c.enrollPath = path
+	return c
+}
+
+func (c *MFAConfigurer) VerifyPath(path string) *MFAConfigurer {
+	c.verifyPath = path
+	return c
+}
+
+func (c *MFAConfigurer) CodeParameter(name string) *MFAConfigurer {
+	c.parameter = name
+	return c
+}
+
+func (c *MFAConfigurer) ReenrollAge(maxAge time.Duration) *MFAConfigurer {
+	c.reenrollAge = maxAge
+	return c
+}
+
+func (c *MFAConfigurer) Throttle(throttler *middlewares.Throttler) *MFAConfigurer {
+	c.throttler = throttler
+	return c
+}
+
+func (c *MFAConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *MFAConfigurer {
+	c.failureHandler = handler
+	return c
+}
+
+func (c *MFAConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the user
+// has been authenticated
+func (c *MFAConfigurer) Order() int {
+	return 13
+}
+
+func (c *MFAConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.store == nil {
+		panic("call MFAConfigurer.Store() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewMFAMiddleware(
+			builder.subject,
+			c.store,
+			middlewares.WithTOTP(c.totp),
+			middlewares.WithMFAIssuer(c.issuer),
+			middlewares.WithMFAEnrollPath(c.enrollPath),
+			middlewares.WithMFAVerifyPath(c.verifyPath),
+			middlewares.WithMFACodeParameter(c.parameter),
+			middlewares.WithMFAReenrollAge(c.reenrollAge),
+			middlewares.WithMFAThrottler(c.throttler),
+			middlewares.WithMFAFailureHandler(c.failureHandler),
+		).Handle)
+}
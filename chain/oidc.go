@@ -0,0 +1,96 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"github.com/shrinex/shield/security"
+	"net/http"
+)
+
+type (
+	OIDCLoginConfigurer struct {
+		builder        *Builder
+		client         *middlewares.OIDCClient
+		httpClient     *http.Client
+		store          middlewares.AuthorizationRequestStore
+		path           string
+		principalClaim string
+		loginOpts      []security.LoginOption
+		successHandler func(http.ResponseWriter, *http.Request)
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*OIDCLoginConfigurer)(nil)
+
+func (c *OIDCLoginConfigurer) Client(client *middlewares.OIDCClient) *OIDCLoginConfigurer {
+	c.client = client
+	return c
+}
+
+func (c *OIDCLoginConfigurer) HTTPClient(client *http.Client) *OIDCLoginConfigurer {
+	c.httpClient = client
+	return c
+}
+
+func (c *OIDCLoginConfigurer) AuthorizationRequestStore(store middlewares.AuthorizationRequestStore) *OIDCLoginConfigurer {
+	c.store = store
+	return c
+}
+
+func (c *OIDCLoginConfigurer) LoginPath(path string) *OIDCLoginConfigurer {
+	c.path = path
+	return c
+}
+
+func (c *OIDCLoginConfigurer) PrincipalClaim(name string) *OIDCLoginConfigurer {
+	c.principalClaim = name
+	return c
+}
+
+func (c *OIDCLoginConfigurer) LoginOptions(opts ...security.LoginOption) *OIDCLoginConfigurer {
+	c.loginOpts = append(c.loginOpts, opts...)
+	return c
+}
+
+func (c *OIDCLoginConfigurer) WhenSucceeded(handler func(http.ResponseWriter, *http.Request)) *OIDCLoginConfigurer {
+	c.successHandler = handler
+	return c
+}
+
+func (c *OIDCLoginConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *OIDCLoginConfigurer {
+	c.failureHandler = handler
+	return c
+}
+
+func (c *OIDCLoginConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the login and callback
+// endpoints are reachable without being authenticated
+func (c *OIDCLoginConfigurer) Order() int {
+	return 5
+}
+
+func (c *OIDCLoginConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.client == nil {
+		panic("call OIDCLoginConfigurer.Client() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewOIDCLoginMiddleware(
+			builder.subject,
+			c.client,
+			middlewares.WithOIDCHTTPClient(c.httpClient),
+			middlewares.WithAuthorizationRequestStore(c.store),
+			middlewares.WithOIDCLoginPath(c.path),
+			middlewares.WithOIDCPrincipalClaim(c.principalClaim),
+			middlewares.WithOIDCLoginOptions(c.loginOpts...),
+			middlewares.WithOIDCFingerprint(builder.fingerprinter),
+			middlewares.WithOIDCSuccessHandler(c.successHandler),
+			middlewares.WithOIDCFailureHandler(c.failureHandler),
+			middlewares.WithOIDCEvents(builder.events),
+		).Handle)
+}
@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"fmt"
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"net/http"
+)
+
+type (
+	// PreAuthenticatedConfigurer trusts the identity
+	// forwarded by authenticating reverse proxies
+	PreAuthenticatedConfigurer struct {
+		builder  *Builder
+		trusted  []string
+		opts     []middlewares.ProxyOption
+		includes []string
+		excludes []string
+		matcher  ant.Matcher
+		handler  func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*PreAuthenticatedConfigurer)(nil)
+
+// TrustedProxies specifies the CIDRs or IPs of the proxies
+func (c *PreAuthenticatedConfigurer) TrustedProxies(cidrs ...string) *PreAuthenticatedConfigurer {
+	c.trusted = append(c.trusted, cidrs...)
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) UserHeader(name string) *PreAuthenticatedConfigurer {
+	c.opts = append(c.opts, middlewares.WithForwardedUserHeader(name))
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) GroupsHeader(name string) *PreAuthenticatedConfigurer {
+	c.opts = append(c.opts, middlewares.WithForwardedGroupsHeader(name))
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) MapGroupsWith(mapper middlewares.GroupMapper) *PreAuthenticatedConfigurer {
+	c.opts = append(c.opts, middlewares.WithGroupMapper(mapper))
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) AntMatches(patterns ...string) *PreAuthenticatedConfigurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) AnyRequests() *PreAuthenticatedConfigurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) AntExcludes(patterns ...string) *PreAuthenticatedConfigurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) Use(matcher ant.Matcher) *PreAuthenticatedConfigurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *PreAuthenticatedConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *PreAuthenticatedConfigurer) And() *Builder {
+	return c.builder
+}
+
+func (c *PreAuthenticatedConfigurer) Order() int {
+	return 10
+}
+
+func (c *PreAuthenticatedConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if len(c.trusted) == 0 {
+		panic("call PreAuthenticatedConfigurer.TrustedProxies() first")
+	}
+	trusted, err := middlewares.ParseTrustedProxies(c.trusted...)
+	if err != nil {
+		panic(fmt.Sprintf("PreAuthenticatedConfigurer: %s", err.Error()))
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanism(middlewares.NewProxyMechanism(trusted, c.opts...)),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
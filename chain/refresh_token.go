@@ -0,0 +1,76 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	"net/http"
+)
+
+type (
+	RefreshTokenConfigurer struct {
+		builder        *Builder
+		service        *middlewares.RefreshTokenService
+		path           string
+		parameter      string
+		successHandler func(http.ResponseWriter, *http.Request)
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*RefreshTokenConfigurer)(nil)
+
+// Use specifies the service that rotates refresh tokens, pass the same
+// one to FormLoginConfigurer.IssueRefreshTokens to issue them on login
+func (c *RefreshTokenConfigurer) Use(service *middlewares.RefreshTokenService) *RefreshTokenConfigurer {
+	c.service = service
+	return c
+}
+
+func (c *RefreshTokenConfigurer) RefreshPath(path string) *RefreshTokenConfigurer {
+	c.path = path
+	return c
+}
+
+func (c *RefreshTokenConfigurer) RefreshTokenParameter(name string) *RefreshTokenConfigurer {
+	c.parameter = name
+	return c
+}
+
+func (c *RefreshTokenConfigurer) WhenSucceeded(handler func(http.ResponseWriter, *http.Request)) *RefreshTokenConfigurer {
+	c.successHandler = handler
+	return c
+}
+
+func (c *RefreshTokenConfigurer) WhenFailed(handler func(http.ResponseWriter, *http.Request, error)) *RefreshTokenConfigurer {
+	c.failureHandler = handler
+	return c
+}
+
+func (c *RefreshTokenConfigurer) And() *Builder {
+	return c.builder
+}
+
+// Order makes sure the refresh endpoint is
+// reachable without being authenticated
+func (c *RefreshTokenConfigurer) Order() int {
+	return 5
+}
+
+func (c *RefreshTokenConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.service == nil {
+		panic("call RefreshTokenConfigurer.Use() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewRefreshMiddleware(
+			builder.subject,
+			c.service,
+			middlewares.WithRefreshPath(c.path),
+			middlewares.WithRefreshTokenParameter(c.parameter),
+			middlewares.WithRefreshFingerprint(builder.fingerprinter),
+			middlewares.WithRefreshEvents(builder.events),
+			middlewares.WithRefreshSuccessHandler(c.successHandler),
+			middlewares.WithRefreshFailureHandler(c.failureHandler),
+		).Handle)
+}
@@ -2,16 +2,35 @@ package chain
 
 import (
 	"github.com/shrinex/shield-web/middlewares"
+	"net/http"
 )
 
 type (
 	SessionManagementConfigurer struct {
-		builder *Builder
+		builder       *Builder
+		fingerprinter middlewares.Fingerprinter
+		policy        middlewares.BindingPolicy
+		handler       func(http.ResponseWriter, *http.Request, error)
 	}
 )
 
 var _ Configurer = (*SessionManagementConfigurer)(nil)
 
+// BindTo binds sessions to the client fingerprint, see middlewares.WithSessionBinding,
+// the login endpoints are configured before this one, so the fingerprinter
+// is handed to the builder right away for them to bind sessions at login
+func (c *SessionManagementConfigurer) BindTo(fingerprinter middlewares.Fingerprinter, policy middlewares.BindingPolicy) *SessionManagementConfigurer {
+	c.fingerprinter = fingerprinter
+	c.policy = policy
+	c.builder.fingerprinter = fingerprinter
+	return c
+}
+
+func (c *SessionManagementConfigurer) WhenMismatched(handler func(http.ResponseWriter, *http.Request, error)) *SessionManagementConfigurer {
+	c.handler = handler
+	return c
+}
+
 func (c *SessionManagementConfigurer) And() *Builder {
 	return c.builder
 }
@@ -27,6 +46,8 @@ func (c *SessionManagementConfigurer) Configure(builder *Builder) {
 	builder.chain = append(builder.chain,
 		middlewares.NewSessionMiddleware(
 			builder.subject,
+			middlewares.WithSessionBinding(c.fingerprinter, c.policy),
+			middlewares.WithFingerprintMismatchHandler(builder.unauthorizedHandler(c.handler)),
 		).Handle,
 	)
 }
@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"net/http"
+	"time"
+)
+
+type (
+	SignatureConfigurer struct {
+		builder  *Builder
+		store    middlewares.SigningKeyStore
+		opts     []middlewares.SignatureOption
+		includes []string
+		excludes []string
+		matcher  ant.Matcher
+		handler  func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*SignatureConfigurer)(nil)
+
+func (c *SignatureConfigurer) Keys(store middlewares.SigningKeyStore) *SignatureConfigurer {
+	c.store = store
+	return c
+}
+
+func (c *SignatureConfigurer) Nonces(store middlewares.NonceStore) *SignatureConfigurer {
+	c.opts = append(c.opts, middlewares.WithNonceStore(store))
+	return c
+}
+
+func (c *SignatureConfigurer) SignedHeaders(headers ...string) *SignatureConfigurer {
+	c.opts = append(c.opts, middlewares.WithSignedHeaders(headers...))
+	return c
+}
+
+func (c *SignatureConfigurer) Window(window time.Duration) *SignatureConfigurer {
+	c.opts = append(c.opts, middlewares.WithSignatureWindow(window))
+	return c
+}
+
+func (c *SignatureConfigurer) MaxBody(n int64) *SignatureConfigurer {
+	c.opts = append(c.opts, middlewares.WithSignatureMaxBody(n))
+	return c
+}
+
+func (c *SignatureConfigurer) AntMatches(patterns ...string) *SignatureConfigurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *SignatureConfigurer) AnyRequests() *SignatureConfigurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *SignatureConfigurer) AntExcludes(patterns ...string) *SignatureConfigurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *SignatureConfigurer) Use(matcher ant.Matcher) *SignatureConfigurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *SignatureConfigurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *SignatureConfigurer {
+	c.handler = handler
+	return c
+}
+
+func (c *SignatureConfigurer) And() *Builder {
+	return c.builder
+}
+
+func (c *SignatureConfigurer) Order() int {
+	return 10
+}
+
+func (c *SignatureConfigurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	if c.store == nil {
+		panic("call SignatureConfigurer.Keys() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanism(middlewares.NewSignatureMechanism(c.store, c.opts...)),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
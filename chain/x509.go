@@ -0,0 +1,79 @@
+package chain
+
+import (
+	"github.com/shrinex/shield-web/middlewares"
+	ant "github.com/shrinex/shield-web/pattern"
+	"net/http"
+)
+
+type (
+	X509Configurer struct {
+		builder  *Builder
+		opts     []middlewares.X509Option
+		includes []string
+		excludes []string
+		matcher  ant.Matcher
+		handler  func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+var _ Configurer = (*X509Configurer)(nil)
+
+func (c *X509Configurer) ExtractPrincipalWith(extractor middlewares.X509PrincipalExtractor) *X509Configurer {
+	c.opts = append(c.opts, middlewares.WithX509PrincipalExtractor(extractor))
+	return c
+}
+
+func (c *X509Configurer) MapAuthoritiesWith(mapper middlewares.X509AuthorityMapper) *X509Configurer {
+	c.opts = append(c.opts, middlewares.WithX509AuthorityMapper(mapper))
+	return c
+}
+
+func (c *X509Configurer) AntMatches(patterns ...string) *X509Configurer {
+	c.includes = append(c.includes, patterns...)
+	return c
+}
+
+func (c *X509Configurer) AnyRequests() *X509Configurer {
+	c.AntMatches(ant.MatchAll)
+	return c
+}
+
+func (c *X509Configurer) AntExcludes(patterns ...string) *X509Configurer {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
+func (c *X509Configurer) Use(matcher ant.Matcher) *X509Configurer {
+	c.matcher = matcher
+	return c
+}
+
+func (c *X509Configurer) WhenUnauthorized(handler func(http.ResponseWriter, *http.Request, error)) *X509Configurer {
+	c.handler = handler
+	return c
+}
+
+func (c *X509Configurer) And() *Builder {
+	return c.builder
+}
+
+func (c *X509Configurer) Order() int {
+	return 10
+}
+
+func (c *X509Configurer) Configure(builder *Builder) {
+	if builder.subject == nil {
+		panic("call Builder.Subject() first")
+	}
+	builder.chain = append(builder.chain,
+		middlewares.NewAuthcMiddleware(
+			builder.subject,
+			middlewares.WithMechanism(middlewares.NewX509Mechanism(c.opts...)),
+			middlewares.WithMatcher(c.matcher),
+			middlewares.WithPatterns(c.includes...),
+			middlewares.WithExcludePatterns(c.excludes...),
+			middlewares.WithUnauthorizedHandler(builder.unauthorizedHandler(c.handler)),
+			middlewares.WithAuthcEvents(builder.events),
+		).Handle)
+}
@@ -0,0 +1,125 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+type (
+	// Claims is the decoded payload of a JWT
+	Claims map[string]any
+
+	claimsCtxKey struct{}
+)
+
+const (
+	IssuerClaim    = "iss"
+	SubjectClaim   = "sub"
+	AudienceClaim  = "aud"
+	ExpiresAtClaim = "exp"
+	NotBeforeClaim = "nbf"
+	IssuedAtClaim  = "iat"
+	IDClaim        = "jti"
+	NonceClaim     = "nonce"
+	ScopeClaim     = "scope"
+)
+
+// NewContext returns a copy of ctx which carries the specified Claims
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsCtxKey{}, claims)
+}
+
+// FromContext returns the Claims stored in ctx, if any
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsCtxKey{}).(Claims)
+	return claims, ok && claims != nil
+}
+
+// Issuer returns the `iss` claim
+func (c Claims) Issuer() string {
+	return c.String(IssuerClaim)
+}
+
+// Subject returns the `sub` claim
+func (c Claims) Subject() string {
+	return c.String(SubjectClaim)
+}
+
+// Audience returns the `aud` claim, which can be either a string or an array,
+// a string is a single audience, which unlike `scope` may contain whitespaces
+func (c Claims) Audience() []string {
+	if aud, ok := c[AudienceClaim].(string); ok {
+		return []string{aud}
+	}
+
+	return c.Strings(AudienceClaim)
+}
+
+// ExpiresAt returns the `exp` claim
+func (c Claims) ExpiresAt() (time.Time, bool) {
+	return c.Time(ExpiresAtClaim)
+}
+
+// NotBefore returns the `nbf` claim
+func (c Claims) NotBefore() (time.Time, bool) {
+	return c.Time(NotBeforeClaim)
+}
+
+// IssuedAt returns the `iat` claim
+func (c Claims) IssuedAt() (time.Time, bool) {
+	return c.Time(IssuedAtClaim)
+}
+
+// String returns the named claim if it is a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the named claim as a string slice, a string
+// claim is split by whitespaces, e.g. the `scope` claim
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []any:
+		ss := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	default:
+		return nil
+	}
+}
+
+// Time returns the named claim as a NumericDate
+func (c Claims) Time(name string) (time.Time, bool) {
+	var secs float64
+	switch v := c[name].(type) {
+	case float64:
+		secs = v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		secs = f
+	case int64:
+		secs = float64(v)
+	case int:
+		secs = float64(v)
+	default:
+		return time.Time{}, false
+	}
+
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true
+}
@@ -0,0 +1,288 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// JWK is a JSON Web Key as defined by RFC 7517, only
+	// the members needed for signature verification
+	JWK struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid,omitempty"`
+		Use       string `json:"use,omitempty"`
+		Algorithm string `json:"alg,omitempty"`
+		Curve     string `json:"crv,omitempty"`
+		N         string `json:"n,omitempty"`
+		E         string `json:"e,omitempty"`
+		X         string `json:"x,omitempty"`
+		Y         string `json:"y,omitempty"`
+	}
+
+	// JWKSOption can be used to customize JWKS
+	JWKSOption func(*JWKS)
+
+	// JWKS is a KeySource that fetches keys from a JWK Set URL, keys
+	// are cached, and fetched again once they are stale or an
+	// unknown key id shows up, e.g. after the issuer rotated keys,
+	// concurrent requests share one fetch, and the stale keys are
+	// kept if the issuer can not be reached
+	JWKS struct {
+		url         string
+		client      *http.Client
+		ttl         time.Duration
+		minInterval time.Duration
+		mu          sync.Mutex
+		keys        StaticKeys
+		err         error
+		fetchedAt   time.Time
+		attemptedAt time.Time
+		call        *jwksCall
+	}
+
+	// jwksCall is a fetch in flight
+	jwksCall struct {
+		done chan struct{}
+		err  error
+	}
+)
+
+const (
+	DefaultJWKSTTL         = time.Hour
+	DefaultJWKSMinInterval = time.Minute
+
+	maxJWKSBody = 1 << 20
+)
+
+var (
+	_ KeySource = (*JWKS)(nil)
+
+	// ErrUnsupportedKey is returned when a JWK can not be used to verify signatures
+	ErrUnsupportedKey = errors.New("unsupported jwk")
+	// ErrKeysUnavailable is returned when the JWK Set can not be fetched
+	ErrKeysUnavailable = errors.New("jwks unavailable")
+)
+
+// NewJWKS returns a JWKS that fetches keys from the specified URL
+func NewJWKS(url string, opts ...JWKSOption) *JWKS {
+	s := &JWKS{url: url}
+
+	for _, f := range opts {
+		f(s)
+	}
+
+	if s.client == nil {
+		s.client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if s.ttl <= 0 {
+		s.ttl = DefaultJWKSTTL
+	}
+
+	if s.minInterval <= 0 {
+		s.minInterval = DefaultJWKSMinInterval
+	}
+
+	return s
+}
+
+func (s *JWKS) Keys(ctx context.Context, id string) ([]*Key, error) {
+	s.mu.Lock()
+	now := time.Now()
+	keys, _ := s.keys.Keys(ctx, id)
+	if now.Sub(s.fetchedAt) < s.ttl && (len(keys) > 0 || len(id) == 0) {
+		s.mu.Unlock()
+		return keys, nil
+	}
+
+	// the issuer may have rotated keys, but do not let forged key
+	// ids, nor an unreachable issuer, trigger a fetch on every request
+	call := s.call
+	if call == nil && now.Sub(s.attemptedAt) >= s.minInterval {
+		call = &jwksCall{done: make(chan struct{})}
+		s.call = call
+		s.attemptedAt = now
+		go s.fetch(call)
+	}
+	err := s.err
+	s.mu.Unlock()
+
+	if call != nil {
+		select {
+		case <-call.done:
+			err = call.err
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, _ = s.keys.Keys(ctx, id)
+	if len(keys) == 0 && err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// fetch runs in background, so that it is neither canceled
+// by the request that triggered it, nor holds the lock
+func (s *JWKS) fetch(call *jwksCall) {
+	keys, err := s.get(context.Background())
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = time.Now()
+	}
+	s.err = err
+	s.call = nil
+	s.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+func (s *JWKS) get(ctx context.Context) (StaticKeys, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: new request: %s", ErrKeysUnavailable, err.Error())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeysUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrKeysUnavailable, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBody))
+	if err != nil {
+		return nil, fmt.Errorf("%w: read response: %s", ErrKeysUnavailable, err.Error())
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeysUnavailable, err.Error())
+	}
+
+	return keys, nil
+}
+
+// ParseJWKS parses a JWK Set, keys not for signature
+// verification or of unsupported types are skipped
+func ParseJWKS(data []byte) (StaticKeys, error) {
+	var set struct {
+		Keys []*JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(StaticKeys, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.Key()
+		if errors.Is(err, ErrUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Key converts this JWK to a verification Key
+func (k *JWK) Key() (*Key, error) {
+	switch k.KeyType {
+	case "RSA":
+		if len(k.Algorithm) > 0 && k.Algorithm != RS256 {
+			return nil, ErrUnsupportedKey
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("jwk %s: invalid exponent", k.KeyID)
+		}
+		return NewRS256Key(k.KeyID, &rsa.PublicKey{N: n, E: int(e.Int64())}), nil
+	case "EC":
+		if k.Curve != "P-256" || (len(k.Algorithm) > 0 && k.Algorithm != ES256) {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("jwk %s: point not on curve", k.KeyID)
+		}
+		return NewES256Key(k.KeyID, pub), nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("decode jwk member: %w", ErrMalformed)
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func WithJWKSClient(client *http.Client) JWKSOption {
+	return func(s *JWKS) {
+		s.client = client
+	}
+}
+
+// WithJWKSTTL specifies how long fetched keys are trusted
+func WithJWKSTTL(ttl time.Duration) JWKSOption {
+	return func(s *JWKS) {
+		s.ttl = ttl
+	}
+}
+
+// WithJWKSMinInterval specifies the minimum interval between two
+// fetches triggered by unknown key ids, or retried after a failure
+func WithJWKSMinInterval(interval time.Duration) JWKSOption {
+	return func(s *JWKS) {
+		s.minInterval = interval
+	}
+}
@@ -0,0 +1,79 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	var (
+		calls   int32
+		failing int32
+		release = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{"keys": []*JWK{{
+			KeyType: "EC",
+			KeyID:   "k1",
+			Curve:   "P-256",
+			X:       base64.RawURLEncoding.EncodeToString(priv.X.FillBytes(make([]byte, 32))),
+			Y:       base64.RawURLEncoding.EncodeToString(priv.Y.FillBytes(make([]byte, 32))),
+		}}}))
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL, WithJWKSTTL(time.Nanosecond), WithJWKSMinInterval(50*time.Millisecond))
+
+	// concurrent requests share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := jwks.Keys(context.TODO(), "k1")
+			assert.NoError(t, err)
+			assert.Len(t, keys, 1)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// stale keys are served while the issuer fails
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(60 * time.Millisecond)
+	keys, err := jwks.Keys(context.TODO(), "k1")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// and retried no more than once per interval
+	for i := 0; i < 4; i++ {
+		_, err = jwks.Keys(context.TODO(), "forged")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,67 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+)
+
+type (
+	// Key is a verification key bound to a signing algorithm
+	Key struct {
+		// ID matches the `kid` header, empty matches any
+		ID string
+		// Algorithm is one of HS256, RS256 and ES256
+		Algorithm string
+		// Value is []byte for HS256, *rsa.PublicKey for
+		// RS256 and *ecdsa.PublicKey for ES256
+		Value any
+	}
+
+	// KeySource is responsible for loading verification keys
+	KeySource interface {
+		// Keys returns the candidate keys for the specified key id, which can be empty
+		Keys(context.Context, string) ([]*Key, error)
+	}
+
+	// StaticKeys is a KeySource that never changes
+	StaticKeys []*Key
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var _ KeySource = (StaticKeys)(nil)
+
+// NewHS256Key returns a Key that verifies HMAC SHA-256 signatures
+func NewHS256Key(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: HS256, Value: secret}
+}
+
+// NewRS256Key returns a Key that verifies RSASSA-PKCS1-v1_5 SHA-256 signatures
+func NewRS256Key(id string, pub *rsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: RS256, Value: pub}
+}
+
+// NewES256Key returns a Key that verifies ECDSA P-256 SHA-256 signatures
+func NewES256Key(id string, pub *ecdsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: ES256, Value: pub}
+}
+
+func (s StaticKeys) Keys(_ context.Context, id string) ([]*Key, error) {
+	if len(id) == 0 {
+		return s, nil
+	}
+
+	keys := make([]*Key, 0, 1)
+	for _, key := range s {
+		if len(key.ID) == 0 || key.ID == id {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
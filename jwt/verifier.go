@@ -0,0 +1,258 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type (
+	// Header is the decoded JOSE header of a JWT
+	Header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid,omitempty"`
+		Type      string `json:"typ,omitempty"`
+	}
+
+	// VerifierOption can be used to customize Verifier
+	VerifierOption func(*Verifier)
+
+	// Verifier verifies the signature and the registered claims of JWTs
+	Verifier struct {
+		keys      KeySource
+		issuer    string
+		audiences []string
+		expiry    bool
+		leeway    time.Duration
+		nowFunc   func() time.Time
+	}
+)
+
+var (
+	// ErrMalformed is returned when the token is not a well-formed JWS compact serialization
+	ErrMalformed = fmt.Errorf("malformed jwt: %w", authc.ErrInvalidToken)
+	// ErrUnsupportedAlgorithm is returned when no key is bound to the `alg` header
+	ErrUnsupportedAlgorithm = fmt.Errorf("unsupported jwt algorithm: %w", authc.ErrInvalidToken)
+	// ErrSignatureInvalid is returned when the signature does not verify
+	ErrSignatureInvalid = fmt.Errorf("jwt signature invalid: %w", authc.ErrInvalidToken)
+	// ErrMissingExpiry is returned when the `exp` claim is required but absent
+	ErrMissingExpiry = fmt.Errorf("jwt expiry missing: %w", authc.ErrInvalidToken)
+	// ErrExpired is returned when the `exp` claim has passed
+	ErrExpired = fmt.Errorf("jwt expired: %w", authc.ErrInvalidToken)
+	// ErrNotValidYet is returned when the `nbf` claim is in the future
+	ErrNotValidYet = fmt.Errorf("jwt not valid yet: %w", authc.ErrInvalidToken)
+	// ErrIssuerInvalid is returned when the `iss` claim mismatches
+	ErrIssuerInvalid = fmt.Errorf("jwt issuer invalid: %w", authc.ErrInvalidToken)
+	// ErrAudienceInvalid is returned when the `aud` claim mismatches
+	ErrAudienceInvalid = fmt.Errorf("jwt audience invalid: %w", authc.ErrInvalidToken)
+)
+
+// NewVerifier returns a Verifier that verifies signatures with the specified keys
+func NewVerifier(keys KeySource, opts ...VerifierOption) *Verifier {
+	v := &Verifier{keys: keys}
+
+	for _, f := range opts {
+		f(v)
+	}
+
+	if v.nowFunc == nil {
+		v.nowFunc = time.Now
+	}
+
+	return v
+}
+
+// Verify verifies the specified token and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if err = v.verifySignature(ctx, &header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, ErrMalformed
+	}
+
+	if err = v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(ctx context.Context, header *Header, signingInput string, signature []byte) error {
+	keys, err := v.keys.Keys(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	supported := false
+	for _, key := range keys {
+		// the algorithm is bound to key, so that an attacker
+		// can not switch e.g. from RS256 to HS256
+		if key.Algorithm != header.Algorithm {
+			continue
+		}
+
+		supported = true
+		if verify(key, signingInput, signature) {
+			return nil
+		}
+	}
+
+	if !supported {
+		return ErrUnsupportedAlgorithm
+	}
+
+	return ErrSignatureInvalid
+}
+
+func (v *Verifier) verifyClaims(claims Claims) error {
+	now := v.nowFunc()
+
+	if _, ok := claims[ExpiresAtClaim]; ok {
+		exp, ok := claims.ExpiresAt()
+		if !ok {
+			return ErrMalformed
+		}
+		if !now.Before(exp.Add(v.leeway)) {
+			return ErrExpired
+		}
+	} else if v.expiry {
+		return ErrMissingExpiry
+	}
+
+	if _, ok := claims[NotBeforeClaim]; ok {
+		nbf, ok := claims.NotBefore()
+		if !ok {
+			return ErrMalformed
+		}
+		if now.Add(v.leeway).Before(nbf) {
+			return ErrNotValidYet
+		}
+	}
+
+	if len(v.issuer) > 0 && claims.Issuer() != v.issuer {
+		return ErrIssuerInvalid
+	}
+
+	if len(v.audiences) > 0 && !v.audienceMatches(claims.Audience()) {
+		return ErrAudienceInvalid
+	}
+
+	return nil
+}
+
+func (v *Verifier) audienceMatches(audiences []string) bool {
+	for _, expected := range v.audiences {
+		for _, aud := range audiences {
+			if aud == expected {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func verify(key *Key, signingInput string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch k := key.Value.(type) {
+	case []byte:
+		if key.Algorithm != HS256 {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		if key.Algorithm != RS256 {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS uses the fixed-width R || S representation
+		if key.Algorithm != ES256 || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// WithIssuer requires the `iss` claim to equal to the specified issuer
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the `aud` claim to contain one of the specified audiences
+func WithAudience(audiences ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.audiences = append(v.audiences, audiences...)
+	}
+}
+
+// WithRequiredExpiry rejects tokens without the `exp` claim,
+// which would be valid forever, e.g. access tokens
+func WithRequiredExpiry() VerifierOption {
+	return func(v *Verifier) {
+		v.expiry = true
+	}
+}
+
+// WithLeeway allows the specified clock skew when validating `exp` and `nbf`
+func WithLeeway(leeway time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+// WithNowFunc replaces the clock used to validate `exp` and `nbf`
+func WithNowFunc(nowFunc func() time.Time) VerifierOption {
+	return func(v *Verifier) {
+		v.nowFunc = nowFunc
+	}
+}
@@ -0,0 +1,160 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func sign(t *testing.T, alg string, key any, claims Claims) string {
+	header, err := json.Marshal(Header{Algorithm: alg, Type: "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case RS256:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	verifier := NewVerifier(StaticKeys{
+		NewHS256Key("", secret),
+		NewRS256Key("", &rsaKey.PublicKey),
+		NewES256Key("", &ecKey.PublicKey),
+	}, WithNowFunc(func() time.Time { return now }))
+
+	claims := Claims{"sub": "archer", "exp": now.Add(time.Minute).Unix()}
+	for alg, key := range map[string]any{HS256: secret, RS256: rsaKey, ES256: ecKey} {
+		parsed, err := verifier.Verify(context.TODO(), sign(t, alg, key, claims))
+		assert.NoError(t, err, alg)
+		assert.Equal(t, "archer", parsed.Subject())
+	}
+
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, []byte("other"), claims))
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	_, err = verifier.Verify(context.TODO(), "a.b")
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	verifier := NewVerifier(StaticKeys{NewRS256Key("", &rsaKey.PublicKey)})
+
+	// sign with the public key as HMAC secret
+	token := sign(t, HS256, rsaKey.PublicKey.N.Bytes(), Claims{"sub": "archer"})
+	_, err = verifier.Verify(context.TODO(), token)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	_, err = verifier.Verify(context.TODO(), sign(t, "none", nil, Claims{"sub": "archer"}))
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestVerifyRegisteredClaims(t *testing.T) {
+	secret := []byte("secret")
+	verifier := NewVerifier(StaticKeys{NewHS256Key("", secret)},
+		WithIssuer("https://issuer.example"),
+		WithAudience("api"),
+		WithLeeway(30*time.Second),
+		WithNowFunc(func() time.Time { return now }),
+	)
+
+	valid := func() Claims {
+		return Claims{
+			"sub": "archer",
+			"iss": "https://issuer.example",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Unix(),
+		}
+	}
+
+	_, err := verifier.Verify(context.TODO(), sign(t, HS256, secret, valid()))
+	assert.NoError(t, err)
+
+	// within leeway
+	claims := valid()
+	claims["exp"] = now.Add(-10 * time.Second).Unix()
+	claims["nbf"] = now.Add(10 * time.Second).Unix()
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.NoError(t, err)
+
+	claims = valid()
+	claims["exp"] = now.Add(-time.Minute).Unix()
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	claims = valid()
+	claims["nbf"] = now.Add(time.Minute).Unix()
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrNotValidYet)
+
+	claims = valid()
+	claims["iss"] = "https://evil.example"
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrIssuerInvalid)
+
+	claims = valid()
+	claims["aud"] = "web"
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrAudienceInvalid)
+
+	// a string is a single audience rather than a list
+	claims = valid()
+	claims["aud"] = "api"
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.NoError(t, err)
+	claims["aud"] = "web api"
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrAudienceInvalid)
+
+	// `exp` is optional unless required
+	claims = valid()
+	delete(claims, "exp")
+	_, err = verifier.Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.NoError(t, err)
+	_, err = NewVerifier(StaticKeys{NewHS256Key("", secret)},
+		WithRequiredExpiry(),
+		WithNowFunc(func() time.Time { return now }),
+	).Verify(context.TODO(), sign(t, HS256, secret, claims))
+	assert.ErrorIs(t, err, ErrMissingExpiry)
+}
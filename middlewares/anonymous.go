@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+)
+
+type (
+	AnonymousOption func(*AnonymousMiddleware)
+
+	// AnonymousMiddleware installs an anonymous Principal for requests
+	// that are not authenticated, e.g. skipped by AuthcMiddleware,
+	// note that Subject.Authenticated still returns false for it
+	AnonymousMiddleware struct {
+		subject     security.Subject
+		name        string
+		roles       []authz.Role
+		authorities []authz.Authority
+	}
+)
+
+const (
+	DefaultAnonymousPrincipal = "anonymousUser"
+	DefaultAnonymousRole      = "ANONYMOUS"
+)
+
+func NewAnonymousMiddleware(subject security.Subject, opts ...AnonymousOption) *AnonymousMiddleware {
+	m := &AnonymousMiddleware{subject: NewPrincipalSubject(subject)}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.name) == 0 {
+		m.name = DefaultAnonymousPrincipal
+	}
+
+	// roles specified are granted along with the default
+	// one, which authorization rules may rely on
+	if !hasRole(m.roles, DefaultAnonymousRole) {
+		m.roles = append([]authz.Role{authz.NewRole(DefaultAnonymousRole)}, m.roles...)
+	}
+
+	return m
+}
+
+func (m *AnonymousMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.subject.Authenticated(r.Context()) {
+			next(w, r)
+			return
+		}
+
+		next(w, r.WithContext(ContextWithPrincipal(r.Context(), &Principal{
+			Name:        m.name,
+			Roles:       m.roles,
+			Authorities: m.authorities,
+			Anonymous:   true,
+		})))
+	}
+}
+
+func hasRole(roles []authz.Role, desc string) bool {
+	for _, role := range roles {
+		if role.Desc() == desc {
+			return true
+		}
+	}
+
+	return false
+}
+
+func WithAnonymousPrincipal(name string) AnonymousOption {
+	return func(m *AnonymousMiddleware) {
+		m.name = name
+	}
+}
+
+// WithAnonymousRoles specifies roles granted in
+// addition to DefaultAnonymousRole
+func WithAnonymousRoles(roles ...authz.Role) AnonymousOption {
+	return func(m *AnonymousMiddleware) {
+		m.roles = append(m.roles, roles...)
+	}
+}
+
+func WithAnonymousAuthorities(authorities ...authz.Authority) AnonymousOption {
+	return func(m *AnonymousMiddleware) {
+		m.authorities = append(m.authorities, authorities...)
+	}
+}
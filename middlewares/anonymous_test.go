@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnonymous(t *testing.T) {
+	subject := newFakeSubject()
+	subject.credentials["archer"] = "123"
+	subject.roles["archer"] = []authz.Role{authz.NewRole("user")}
+
+	authzm := NewAuthzMiddleware(subject, WithRouteRegistry(
+		pattern.NewRouteRegistry().
+			AntMatches("/catalog/**").HasAnyRole(authz.NewRole(DefaultAnonymousRole), authz.NewRole("user")).And().
+			AnyRequests().Authenticated(),
+	))
+
+	var principal *Principal
+	handler := NewAnonymousMiddleware(subject, WithAnonymousAuthorities(authz.NewAuthority("catalog:read"))).
+		Handle(authzm.Handle(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+		}))
+
+	serve := func(ctx context.Context, path string) int {
+		principal = nil
+		r := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// unauthenticated requests get the anonymous principal
+	assert.Equal(t, http.StatusOK, serve(context.Background(), "/catalog/items"))
+	assert.NotNil(t, principal)
+	assert.True(t, principal.Anonymous)
+	assert.Equal(t, DefaultAnonymousPrincipal, principal.Name)
+	assert.Equal(t, []authz.Role{authz.NewRole(DefaultAnonymousRole)}, principal.Roles)
+	assert.Equal(t, []authz.Authority{authz.NewAuthority("catalog:read")}, principal.Authorities)
+
+	// which is not authenticated
+	assert.Equal(t, http.StatusForbidden, serve(context.Background(), "/orders"))
+
+	// authenticated requests pass through untouched
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(ctx, "/catalog/items"))
+	assert.Nil(t, principal)
+	assert.Equal(t, http.StatusOK, serve(ctx, "/orders"))
+	assert.Nil(t, principal)
+
+	// roles specified are granted along with the default one
+	handler = NewAnonymousMiddleware(subject, WithAnonymousPrincipal("guest"), WithAnonymousRoles(authz.NewRole("visitor"))).
+		Handle(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+		})
+	assert.Equal(t, http.StatusOK, serve(context.Background(), "/catalog/items"))
+	assert.NotNil(t, principal)
+	assert.Equal(t, "guest", principal.Name)
+	assert.Equal(t, []authz.Role{authz.NewRole(DefaultAnonymousRole), authz.NewRole("visitor")}, principal.Roles)
+}
@@ -0,0 +1,175 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// APIKey describes a static key issued to a machine client
+	APIKey struct {
+		// Principal identifies the owner of this key
+		Principal string
+		// Hash is the digest of the key, see HashAPIKey
+		Hash []byte
+		// Roles granted to the owner
+		Roles []authz.Role
+		// Authorities granted to the owner
+		Authorities []authz.Authority
+		// ExpiresAt is the time the key expires, zero means never
+		ExpiresAt time.Time
+	}
+
+	// APIKeyStore is responsible for loading APIKey(s)
+	APIKeyStore interface {
+		// Lookup returns the APIKey matches the specified digest, or nil if not found
+		Lookup(context.Context, []byte) (*APIKey, error)
+	}
+
+	// MemoryAPIKeyStore is an APIKeyStore backed by a slice,
+	// every lookup compares all keys in constant time
+	MemoryAPIKeyStore struct {
+		mu   sync.RWMutex
+		keys []*APIKey
+	}
+
+	apiKeyMechanism struct {
+		store    APIKeyStore
+		resolver TokenResolver
+	}
+)
+
+const (
+	// APIKeyHeader is the default header that carries the API key
+	APIKeyHeader = "X-API-Key"
+	// APIKeyMechanismName is the name of the API key Mechanism
+	APIKeyMechanismName = "apikey"
+)
+
+var (
+	_ APIKeyStore = (*MemoryAPIKeyStore)(nil)
+	_ Mechanism   = (*apiKeyMechanism)(nil)
+
+	// ErrAPIKeyExpired is returned when the API key expires
+	ErrAPIKeyExpired = errors.New("api key expired")
+)
+
+// HashAPIKey returns the SHA-256 digest of the specified key
+func HashAPIKey(key string) []byte {
+	digest := sha256.Sum256([]byte(key))
+	return digest[:]
+}
+
+// Expired returns true if the key expires
+func (k *APIKey) Expired() bool {
+	return !k.ExpiresAt.IsZero() && !nowFunc().Before(k.ExpiresAt)
+}
+
+// NewMemoryAPIKeyStore returns a MemoryAPIKeyStore contains the specified keys
+func NewMemoryAPIKeyStore(keys ...*APIKey) *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: keys}
+}
+
+// Add adds the specified key into this store
+func (s *MemoryAPIKeyStore) Add(key *APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, key)
+}
+
+// Remove removes all keys belong to the specified principal
+func (s *MemoryAPIKeyStore) Remove(principal string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := 0
+	for _, key := range s.keys {
+		if key.Principal != principal {
+			s.keys[j] = key
+			j += 1
+		}
+	}
+	s.keys = s.keys[:j]
+}
+
+func (s *MemoryAPIKeyStore) Lookup(ctx context.Context, digest []byte) (*APIKey, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// do not return early, so that the time taken
+	// does not depend on which key matches
+	var found *APIKey
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(key.Hash, digest) == 1 {
+			found = key
+		}
+	}
+
+	return found, nil
+}
+
+// NewAPIKeyMiddleware returns an AuthcMiddleware that authenticates
+// requests with the API key carried by the X-API-Key header
+func NewAPIKeyMiddleware(subject security.Subject, store APIKeyStore, opts ...AuthcOption) *AuthcMiddleware {
+	return NewAuthcMiddleware(subject, append(opts, WithMechanism(NewAPIKeyMechanism(store, nil)))...)
+}
+
+// NewAPIKeyMechanism returns a Mechanism that authenticates the API key
+// resolved by the specified resolver against the specified store, the owner
+// of the key is stored in context as Principal, if resolver is nil,
+// the key is read from the X-API-Key header
+func NewAPIKeyMechanism(store APIKeyStore, resolver TokenResolver) Mechanism {
+	if resolver == nil {
+		resolver = NewHeaderTokenResolver(APIKeyHeader, "")
+	}
+
+	return &apiKeyMechanism{store: store, resolver: resolver}
+}
+
+func (a *apiKeyMechanism) Name() string {
+	return APIKeyMechanismName
+}
+
+func (a *apiKeyMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	value, err := a.resolver.Resolve(r)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	digest := HashAPIKey(value)
+	key, err := a.store.Lookup(r.Context(), digest)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	// stores may look up by digest, make sure the
+	// final comparison is always in constant time
+	if key == nil || subtle.ConstantTimeCompare(key.Hash, digest) != 1 {
+		return r.Context(), authc.ErrUnauthenticated
+	}
+
+	if key.Expired() {
+		return r.Context(), ErrAPIKeyExpired
+	}
+
+	return ContextWithPrincipal(r.Context(), &Principal{
+		Name:        key.Principal,
+		Roles:       key.Roles,
+		Authorities: key.Authorities,
+	}), nil
+}
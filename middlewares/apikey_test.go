@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	subject := newFakeSubject()
+	store := NewMemoryAPIKeyStore(&APIKey{
+		Principal: "robot",
+		Hash:      HashAPIKey("secret"),
+		Roles:     []authz.Role{authz.NewRole("machine")},
+	}, &APIKey{
+		Principal: "retired",
+		Hash:      HashAPIKey("outdated"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	authzm := NewAuthzMiddleware(subject, WithRouteRegistry(
+		pattern.NewRouteRegistry().AnyRequests().HasRole(authz.NewRole("machine")),
+	))
+	handler := NewAPIKeyMiddleware(subject, store).Handle(authzm.Handle(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "robot", principal.Name)
+	}))
+
+	for key, code := range map[string]int{
+		"":         http.StatusUnauthorized,
+		"unknown":  http.StatusUnauthorized,
+		"outdated": http.StatusUnauthorized,
+		"secret":   http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(key) > 0 {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, code, w.Code, key)
+	}
+}
@@ -1,8 +1,10 @@
 package middlewares
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/shrinex/shield-web/jwt"
 	ant "github.com/shrinex/shield-web/pattern"
 	"github.com/shrinex/shield/authc"
 	"github.com/shrinex/shield/security"
@@ -10,20 +12,61 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"strings"
 )
 
 const (
-	bearer              = "Bearer "
+	bearer              = "Bearer"
 	authorizationHeader = "Authorization"
+	retryAfterHeader    = "Retry-After"
 )
 
 type (
+	// Mechanism authenticates a request with a specific kind of credentials
+	Mechanism interface {
+		// Name identifies the mechanism, e.g. bearer
+		Name() string
+		// Authenticate returns a context carrying the authenticated user, or
+		// an error wraps ErrCredentialsNotFound if the request does not carry
+		// the credentials this mechanism expects, so that the next
+		// mechanism gets a chance
+		Authenticate(*http.Request, security.Subject) (context.Context, error)
+	}
+
+	// Challenger is implemented by Mechanism that needs to challenge
+	// the client once authentication failed, e.g. sets WWW-Authenticate
+	Challenger interface {
+		// Challenge is called right before the unauthorized handler
+		Challenge(http.ResponseWriter, *http.Request, error)
+	}
+
+	// Guard is implemented by Mechanism that vets every request, even the
+	// ones skipped by the patterns, before any mechanism is tried, regardless
+	// of the order they are registered, e.g. to reject identity headers
+	// spoofed by clients
+	Guard interface {
+		// Guard returns an error if the request must be rejected
+		Guard(*http.Request) error
+	}
+
+	// Claimant is implemented by Mechanism whose credentials name the
+	// principal before they are verified, e.g. a username and password,
+	// so that a locked principal is refused without trying them
+	Claimant interface {
+		// Claim returns the principal the request claims to be, if any
+		Claim(*http.Request) (string, bool)
+	}
+
 	AuthcOption func(*AuthcMiddleware)
 
+	mechanismCtxKey struct{}
+
 	AuthcMiddleware struct {
 		subject             security.Subject
 		matcher             ant.Matcher
+		resolver            TokenResolver
+		mechanisms          []Mechanism
+		throttler           *Throttler
+		events              *EventPublisher
 		includePatterns     []string
 		excludePatterns     []string
 		unauthorizedHandler func(http.ResponseWriter, *http.Request, error)
@@ -41,6 +84,14 @@ func NewAuthcMiddleware(subject security.Subject, opts ...AuthcOption) *AuthcMid
 		m.matcher = ant.NewMatcher()
 	}
 
+	if m.resolver == nil {
+		m.resolver = NewBearerTokenResolver()
+	}
+
+	if len(m.mechanisms) == 0 {
+		m.mechanisms = []Mechanism{NewBearerMechanism(m.resolver)}
+	}
+
 	if m.unauthorizedHandler == nil {
 		m.unauthorizedHandler = defaultUnauthorizedHandler
 	}
@@ -50,13 +101,37 @@ func NewAuthcMiddleware(subject security.Subject, opts ...AuthcOption) *AuthcMid
 
 func (m *AuthcMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.guard(w, r) {
+			return
+		}
+
 		if m.shouldSkip(r) {
 			next(w, r)
 			return
 		}
 
-		m.bearerAuth(w, r, next)
+		m.authenticate(w, r, next)
+	}
+}
+
+// guard lets every Guard vet the request, before the patterns are
+// matched, so that e.g. forged identity headers can not reach handlers
+// on skipped paths, it returns false if the request is rejected
+func (m *AuthcMiddleware) guard(w http.ResponseWriter, r *http.Request) bool {
+	for _, mechanism := range m.mechanisms {
+		g, ok := mechanism.(Guard)
+		if !ok {
+			continue
+		}
+
+		if err := g.Guard(r); err != nil {
+			m.events.Publish(r.Context(), NewAuthEvent(r, evalFailure(err), "", mechanism.Name(), err))
+			m.unauthorizedHandler(w, r, err)
+			return false
+		}
 	}
+
+	return true
 }
 
 func (m *AuthcMiddleware) shouldSkip(r *http.Request) bool {
@@ -81,35 +156,106 @@ func (m *AuthcMiddleware) shouldSkip(r *http.Request) bool {
 	return true
 }
 
-func (m *AuthcMiddleware) bearerAuth(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	value, err := parseTokenValue(r)
-	if err != nil {
-		m.unauthorizedHandler(w, r, err)
-		return
+// authenticate tries mechanisms in order, a mechanism finds no credentials
+// falls through to the next one, while invalid credentials fail fast
+func (m *AuthcMiddleware) authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	var err error
+	for _, mechanism := range m.mechanisms {
+		principal := m.claim(mechanism, r)
+		if m.throttler != nil && len(principal) > 0 {
+			if err = m.throttler.Check(r.Context(), principal, ""); err != nil {
+				m.events.Publish(r.Context(), NewAuthEvent(r, evalFailure(err), principal, mechanism.Name(), err))
+				m.unauthorizedHandler(w, r, err)
+				return
+			}
+		}
+
+		var ctx context.Context
+		ctx, err = mechanism.Authenticate(r, m.subject)
+		if err == nil {
+			if m.throttler != nil && len(principal) > 0 {
+				if terr := m.throttler.Succeeded(r.Context(), principal); terr != nil {
+					log.Printf("reset failures failed: %s\n", terr.Error())
+				}
+			}
+			ctx = contextWithMechanism(ctx, mechanism.Name())
+			m.events.Publish(ctx, NewAuthEvent(r, AuthenticationSucceeded, m.principalOf(ctx), mechanism.Name(), nil))
+			next(w, r.WithContext(ctx))
+			return
+		}
+
+		if !errors.Is(err, ErrCredentialsNotFound) {
+			if m.throttler != nil && countsAsFailure(err) {
+				err = m.throttle(r, principal, err)
+			}
+			m.events.Publish(r.Context(), NewAuthEvent(r, evalFailure(err), principal, mechanism.Name(), err))
+			if c, ok := mechanism.(Challenger); ok {
+				c.Challenge(w, r, err)
+			}
+			m.unauthorizedHandler(w, r, err)
+			return
+		}
 	}
 
-	token := authc.NewBearerToken(value)
-	ctx, err := m.subject.Login(r.Context(), token)
-	if err != nil {
-		m.unauthorizedHandler(w, r, err)
-		return
+	// no credentials at all, offer every possible challenge
+	m.events.Publish(r.Context(), NewAuthEvent(r, AuthenticationFailed, "", "", err))
+	for _, mechanism := range m.mechanisms {
+		if c, ok := mechanism.(Challenger); ok {
+			c.Challenge(w, r, err)
+		}
+	}
+	m.unauthorizedHandler(w, r, err)
+}
+
+// throttle records the failure of the principal, if any, and the client
+// IP, and returns a *ThrottleError instead of err once throttled, the
+// IP is never checked before the credentials are, otherwise the users
+// sharing an IP, e.g. behind NAT, would be locked out along with an attacker
+func (m *AuthcMiddleware) throttle(r *http.Request, principal string, err error) error {
+	ip := m.throttler.ClientIP(r)
+	if terr := m.throttler.Failed(r.Context(), principal, ip); terr != nil {
+		log.Printf("record failure failed: %s\n", terr.Error())
+		return err
+	}
+
+	if terr := m.throttler.Check(r.Context(), principal, ip); terr != nil {
+		return terr
 	}
 
-	next(w, r.WithContext(ctx))
+	return err
 }
 
-func parseTokenValue(r *http.Request) (string, error) {
-	val := r.Header.Get(authorizationHeader)
-	if !strings.HasPrefix(val, bearer) {
-		return "", authc.ErrInvalidToken
+// claim returns the principal claimed by the credentials of the
+// request, which is empty if the mechanism does not tell
+func (m *AuthcMiddleware) claim(mechanism Mechanism, r *http.Request) string {
+	c, ok := mechanism.(Claimant)
+	if !ok {
+		return ""
 	}
 
-	token := strings.TrimPrefix(val, bearer)
-	if len(token) == 0 {
-		return "", authc.ErrInvalidToken
+	principal, _ := c.Claim(r)
+	return principal
+}
+
+// principalOf returns the principal of the authenticated context
+func (m *AuthcMiddleware) principalOf(ctx context.Context) string {
+	user, err := NewPrincipalSubject(m.subject).UserDetails(ctx)
+	if err != nil {
+		return ""
 	}
 
-	return token, nil
+	return user.Principal()
+}
+
+func contextWithMechanism(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, mechanismCtxKey{}, name)
+}
+
+// MechanismFromContext returns the name of the Mechanism
+// that authenticated the request, if any
+func MechanismFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(mechanismCtxKey{}).(string)
+	return name, ok
 }
 
 func detailAuthLog(r *http.Request, reason string) {
@@ -123,13 +269,14 @@ func defaultUnauthorizedHandler(w http.ResponseWriter, r *http.Request, err erro
 	detailAuthLog(r, err.Error())
 
 	// if user not setting HTTP header, we set header with 401
-	w.WriteHeader(http.StatusUnauthorized)
+	code := evalStatus(w, err)
+	w.WriteHeader(code)
 
 	bytes, err := json.Marshal(struct {
 		Code    int32  `json:"code"`    // 错误码
 		Message string `json:"message"` // 错误信息
 	}{
-		Code:    http.StatusUnauthorized,
+		Code:    int32(code),
 		Message: evalMessage(err),
 	})
 	if err != nil {
@@ -144,7 +291,48 @@ func defaultUnauthorizedHandler(w http.ResponseWriter, r *http.Request, err erro
 	}
 }
 
+// evalStatus returns the status code of err, which is 401 unless the
+// client is throttled, or the authentication could not be completed
+func evalStatus(w http.ResponseWriter, err error) int {
+	if throttled, retryAfter, ok := evalThrottle(err); ok {
+		w.Header().Set(retryAfterHeader, retryAfter)
+		return throttled
+	}
+
+	if isUnavailable(err) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusUnauthorized
+}
+
+// isUnavailable reports whether err is caused by an authorization
+// server that can not be reached, rather than the credentials
+func isUnavailable(err error) bool {
+	return errors.Is(err, ErrIntrospectionUnavailable) || errors.Is(err, jwt.ErrKeysUnavailable)
+}
+
 func evalMessage(err error) string {
+	if errors.Is(err, jwt.ErrExpired) {
+		return "token已过期"
+	}
+
+	if isUnavailable(err) {
+		return "认证服务暂不可用，请稍后重试"
+	}
+
+	if errors.Is(err, ErrTokenInactive) {
+		return "token已失效"
+	}
+
+	if errors.Is(err, ErrSignatureMalformed) {
+		return "签名格式不正确"
+	}
+
+	if errors.Is(err, ErrUntrustedProxy) {
+		return "请求来源不受信任"
+	}
+
 	if errors.Is(err, authc.ErrInvalidToken) {
 		return "token格式不正确"
 	}
@@ -165,15 +353,97 @@ func evalMessage(err error) string {
 		return "会话已超限，请重新登录"
 	}
 
+	if errors.Is(err, ErrAPIKeyExpired) {
+		return "API Key已过期"
+	}
+
+	if errors.Is(err, ErrAccountLocked) {
+		return "账号已被锁定，请稍后再试"
+	}
+
+	if errors.Is(err, ErrTooManyAttempts) {
+		return "尝试次数过多，请稍后再试"
+	}
+
+	if errors.Is(err, ErrSignatureInvalid) {
+		return "签名不正确"
+	}
+
+	if errors.Is(err, ErrSignatureExpired) {
+		return "请求已过期"
+	}
+
+	if errors.Is(err, ErrNonceReused) {
+		return "请勿重复请求"
+	}
+
+	if errors.Is(err, ErrReauthenticationRequired) {
+		return "登录环境已变化，请重新登录"
+	}
+
+	if errors.Is(err, ErrFingerprintMismatch) {
+		return "登录环境异常"
+	}
+
+	if errors.Is(err, ErrMFARequired) {
+		return "请先完成二次验证"
+	}
+
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return "请先绑定二次验证"
+	}
+
+	if errors.Is(err, ErrInvalidMFACode) {
+		return "验证码不正确"
+	}
+
 	return err.Error()
 }
 
+// WithAuthcEvents publishes authentication outcomes to the specified publisher
+func WithAuthcEvents(events *EventPublisher) AuthcOption {
+	return func(m *AuthcMiddleware) {
+		m.events = events
+	}
+}
+
 func WithMatcher(matcher ant.Matcher) AuthcOption {
 	return func(m *AuthcMiddleware) {
 		m.matcher = matcher
 	}
 }
 
+// WithMechanism is a shortcut of WithMechanisms
+func WithMechanism(mechanism Mechanism) AuthcOption {
+	return WithMechanisms(mechanism)
+}
+
+// WithMechanisms appends the specified mechanisms, which are tried in
+// order, the default bearer Mechanism is used only if none specified,
+// in which case WithTokenResolver takes effect
+func WithMechanisms(mechanisms ...Mechanism) AuthcOption {
+	return func(m *AuthcMiddleware) {
+		m.mechanisms = append(m.mechanisms, mechanisms...)
+	}
+}
+
+func WithTokenResolver(resolver TokenResolver) AuthcOption {
+	return func(m *AuthcMiddleware) {
+		m.resolver = resolver
+	}
+}
+
+// WithThrottler throttles failed authentication attempts per client IP, and
+// per principal if the mechanism is a Claimant, the failures are answered
+// with 429 or 423 once throttled, a locked principal is refused before
+// its credentials are tried, while a throttled IP is never checked
+// before, so requests carrying valid credentials are let through
+func WithThrottler(throttler *Throttler) AuthcOption {
+	return func(m *AuthcMiddleware) {
+		m.throttler = throttler
+	}
+}
+
 func WithUnauthorizedHandler(handler func(http.ResponseWriter, *http.Request, error)) AuthcOption {
 	return func(m *AuthcMiddleware) {
 		m.unauthorizedHandler = handler
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield/authc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMechanismsFallback(t *testing.T) {
+	subject := newFakeSubject()
+	subject.credentials["token"] = "token"
+	store := NewMemoryAPIKeyStore(&APIKey{Principal: "robot", Hash: HashAPIKey("secret")})
+
+	var mechanism string
+	handler := NewAuthcMiddleware(subject, WithMechanisms(
+		NewBearerMechanism(NewBearerTokenResolver()),
+		NewAPIKeyMechanism(store, nil),
+		NewBasicMechanism("", authc.NewAuthenticator(passwordRealm{})),
+	)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		mechanism, _ = MechanismFromContext(r.Context())
+	})
+
+	// no credentials at all
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, []string{"Bearer", `Basic realm="shield", charset="UTF-8"`}, w.Header().Values(wwwAuthenticateHeader))
+
+	// falls through to the api key
+	r.Header.Set(APIKeyHeader, "secret")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, APIKeyMechanismName, mechanism)
+
+	// invalid bearer token fails fast
+	mechanism = ""
+	r.Header.Set(authorizationHeader, "Bearer invalid")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, mechanism)
+
+	r.Header.Set(authorizationHeader, "Bearer token")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, BearerMechanismName, mechanism)
+}
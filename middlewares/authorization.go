@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"time"
 )
 
 type (
@@ -15,10 +17,11 @@ type (
 	AuthzOption func(*AuthzMiddleware)
 
 	AuthzMiddleware struct {
-		mode             AuthzMode
-		subject          security.Subject
-		registry         *pattern.RouteRegistry
-		forbiddenHandler func(http.ResponseWriter, *http.Request)
+		mode               AuthzMode
+		subject            security.Subject
+		source             PolicySource
+		forbiddenHandler   func(http.ResponseWriter, *http.Request)
+		mfaRequiredHandler func(http.ResponseWriter, *http.Request)
 	}
 )
 
@@ -30,38 +33,55 @@ const (
 )
 
 func NewAuthzMiddleware(subject security.Subject, opts ...AuthzOption) *AuthzMiddleware {
-	m := &AuthzMiddleware{subject: subject}
+	// so that predicates are aware of Principal
+	m := &AuthzMiddleware{subject: NewPrincipalSubject(subject)}
 
 	for _, f := range opts {
 		f(m)
 	}
 
-	if m.registry == nil {
-		m.registry = pattern.NewRouteRegistry()
+	if m.source == nil {
+		m.source = NewAtomicPolicySource(pattern.NewRouteRegistry())
 	}
 
 	if m.forbiddenHandler == nil {
 		m.forbiddenHandler = defaultForbiddenHandler
 	}
 
+	if m.mfaRequiredHandler == nil {
+		m.mfaRequiredHandler = defaultMFARequiredHandler
+	}
+
 	return m
 }
 
 func (m *AuthzMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if len(m.registry.Mappings) == 0 {
+		// read once, so that a request is evaluated by a single policy
+		registry := m.source.Registry()
+		if len(registry.Mappings) == 0 {
 			next(w, r)
 			return
 		}
 
-		var deny bool
+		var (
+			deny   bool
+			denied []pattern.URLMapping
+		)
 		if m.mode == Affirmative {
-			deny = m.affirmative(r)
+			deny, denied = m.affirmative(registry, r)
 		} else {
-			deny = m.unanimous(r)
+			deny, denied = m.unanimous(registry, r)
 		}
 
 		if deny {
+			if maxAge, ok := m.requiresMFA(r, denied); ok {
+				challengeMFA(w, maxAge)
+				m.mfaRequiredHandler(w, r)
+				return
+			}
+
+			m.challenge(w, r, denied)
 			m.forbiddenHandler(w, r)
 			return
 		}
@@ -70,42 +90,94 @@ func (m *AuthzMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (m *AuthzMiddleware) unanimous(r *http.Request) bool {
-	for _, mapping := range m.registry.Mappings {
+// unanimous returns true along with the denying mapping if the request is denied
+func (m *AuthzMiddleware) unanimous(registry *pattern.RouteRegistry, r *http.Request) (bool, []pattern.URLMapping) {
+	for _, mapping := range registry.Mappings {
 		if m.excluded(mapping.Excludes, r) {
-			return false
+			return false, nil
 		}
 
 		for _, matcher := range mapping.Includes {
-			if matcher.Matches(r) {
-				if !mapping.Predicate(r, m.subject) {
-					return true
+			if matched, ok := pattern.MatchRequest(matcher, r); ok {
+				if !mapping.Predicate(matched, m.subject) {
+					return true, []pattern.URLMapping{mapping}
 				}
 			}
 		}
 	}
 
-	return false
+	return false, nil
 }
 
-func (m *AuthzMiddleware) affirmative(r *http.Request) bool {
-	deny := 0
-	for _, mapping := range m.registry.Mappings {
+// affirmative returns true along with the denying mappings if the request is denied
+func (m *AuthzMiddleware) affirmative(registry *pattern.RouteRegistry, r *http.Request) (bool, []pattern.URLMapping) {
+	var denied []pattern.URLMapping
+	for _, mapping := range registry.Mappings {
 		if m.excluded(mapping.Excludes, r) {
-			return false
+			return false, nil
 		}
 
 		for _, matcher := range mapping.Includes {
-			if matcher.Matches(r) {
-				if mapping.Predicate(r, m.subject) {
-					return false
+			if matched, ok := pattern.MatchRequest(matcher, r); ok {
+				if mapping.Predicate(matched, m.subject) {
+					return false, nil
 				}
-				deny += 1 // nolint
+				denied = append(denied, mapping)
+			}
+		}
+	}
+
+	return len(denied) > 0, denied
+}
+
+// challenge adds an RFC 6750 insufficient_scope challenge if the
+// request was authenticated by a bearer access token, and all the
+// denying mappings record the authorities they require, which the
+// scope lists, a token of more scope would not help otherwise
+func (m *AuthzMiddleware) challenge(w http.ResponseWriter, r *http.Request, denied []pattern.URLMapping) {
+	name, ok := MechanismFromContext(r.Context())
+	if !ok || !isBearerMechanism(name) || len(denied) == 0 {
+		return
+	}
+
+	var scope []string
+	seen := make(map[string]bool)
+	for _, mapping := range denied {
+		if len(mapping.Authorities) == 0 {
+			return
+		}
+
+		for _, authority := range mapping.Authorities {
+			if desc := authority.Desc(); !seen[desc] {
+				seen[desc] = true
+				scope = append(scope, desc)
 			}
 		}
 	}
 
-	return deny > 0
+	challengeBearer(w, InsufficientScope, "The request requires higher privileges", strings.Join(scope, " "))
+}
+
+// requiresMFA returns the shortest max age if the request is authenticated
+// and all denying mappings require recent MFA, so that verifying the
+// second factor is all the request lacks
+func (m *AuthzMiddleware) requiresMFA(r *http.Request, denied []pattern.URLMapping) (time.Duration, bool) {
+	if !m.subject.Authenticated(r.Context()) {
+		return 0, false
+	}
+
+	var maxAge time.Duration
+	for _, mapping := range denied {
+		if mapping.MFAMaxAge <= 0 {
+			return 0, false
+		}
+
+		if maxAge == 0 || mapping.MFAMaxAge < maxAge {
+			maxAge = mapping.MFAMaxAge
+		}
+	}
+
+	return maxAge, maxAge > 0
 }
 
 func (m *AuthzMiddleware) excluded(excludes []pattern.RouteMatcher, r *http.Request) bool {
@@ -154,6 +226,18 @@ func defaultForbiddenHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func defaultMFARequiredHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("mfa required: %s %s\n", r.Method, r.URL.Path)
+
+	writeJSON(w, http.StatusUnauthorized, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusUnauthorized,
+		Message: evalMessage(ErrMFARequired),
+	})
+}
+
 func WithAuthzMode(mode AuthzMode) AuthzOption {
 	return func(m *AuthzMiddleware) {
 		m.mode = mode
@@ -161,8 +245,14 @@ func WithAuthzMode(mode AuthzMode) AuthzOption {
 }
 
 func WithRouteRegistry(registry *pattern.RouteRegistry) AuthzOption {
+	return WithPolicySource(NewAtomicPolicySource(registry))
+}
+
+// WithPolicySource evaluates the registry provided by the source,
+// which may change while requests are being served
+func WithPolicySource(source PolicySource) AuthzOption {
 	return func(m *AuthzMiddleware) {
-		m.registry = registry
+		m.source = source
 	}
 }
 
@@ -172,6 +262,15 @@ func WithForbiddenHandler(handler func(http.ResponseWriter, *http.Request)) Auth
 	}
 }
 
+// WithMFARequiredHandler specifies the handler of requests denied only because
+// the second factor has not been verified recently, the MFA challenge
+// is added before the handler is called
+func WithMFARequiredHandler(handler func(http.ResponseWriter, *http.Request)) AuthzOption {
+	return func(m *AuthzMiddleware) {
+		m.mfaRequiredHandler = handler
+	}
+}
+
 func WithAffirmativeMode() AuthzOption {
 	return WithAuthzMode(Affirmative)
 }
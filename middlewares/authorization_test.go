@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathVariableIsPrincipal(t *testing.T) {
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	registry := pattern.NewRouteRegistry().
+		AntMatches("/users/{id}/**").PathVariableIsPrincipal("id").
+		AnyRequests().PermitAll()
+
+	handler := NewAuthzMiddleware(subject, WithUnanimousMode(), WithRouteRegistry(registry)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(ctx context.Context, path string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve(context.Background(), "/users/archer/orders"))
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusTeapot, serve(ctx, "/users/archer/orders"))
+	assert.Equal(t, http.StatusForbidden, serve(ctx, "/users/saber/orders"))
+	assert.Equal(t, http.StatusTeapot, serve(ctx, "/orders"))
+}
+
+func TestAccessExpression(t *testing.T) {
+	registry := pattern.NewRouteRegistry().
+		AntMatches("/tenants/{tenant}/**").
+		Access("hasRole('admin') or (hasAuthority('orders:read') and pathVar('tenant') == principal.tenant)").
+		AntMatches("/orders/**").
+		Access("pathVar('tenant') == principal.tenant or pathVar('tenant') != principal.tenant").
+		AnyRequests().PermitAll()
+
+	handler := NewAuthzMiddleware(newFakeSubject(), WithUnanimousMode(), WithRouteRegistry(registry)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(principal *Principal, path string) int {
+		ctx := context.Background()
+		if principal != nil {
+			ctx = ContextWithPrincipal(ctx, principal)
+		}
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		return w.Code
+	}
+
+	reader := &Principal{
+		Name:        "archer",
+		Authorities: []authz.Authority{authz.NewAuthority("orders:read")},
+		Attributes:  map[string]any{"tenant": "acme"},
+	}
+	admin := &Principal{Name: "saber", Roles: []authz.Role{authz.NewRole("admin")}}
+
+	assert.Equal(t, http.StatusForbidden, serve(nil, "/tenants/acme/orders"))
+	assert.Equal(t, http.StatusTeapot, serve(reader, "/tenants/acme/orders"))
+	assert.Equal(t, http.StatusForbidden, serve(reader, "/tenants/umbrella/orders"))
+	assert.Equal(t, http.StatusTeapot, serve(admin, "/tenants/umbrella/orders"))
+
+	// neither the route captures a tenant nor the principal has one
+	stranger := &Principal{Name: "lancer"}
+	assert.Equal(t, http.StatusForbidden, serve(stranger, "/orders/1"))
+	assert.Equal(t, http.StatusForbidden, serve(reader, "/orders/1"))
+}
@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+	"strings"
+)
+
+type (
+	BasicOption func(*basicMechanism)
+
+	basicMechanism struct {
+		realm         string
+		authenticator authc.Authenticator
+		authorization authz.Realm
+		resolver      TokenResolver
+	}
+)
+
+const (
+	basic                 = "Basic"
+	wwwAuthenticateHeader = "WWW-Authenticate"
+
+	// DefaultBasicRealm is the realm used if none specified
+	DefaultBasicRealm = "shield"
+	// BasicMechanismName is the name of the basic Mechanism
+	BasicMechanismName = "basic"
+)
+
+var (
+	_ Mechanism  = (*basicMechanism)(nil)
+	_ Challenger = (*basicMechanism)(nil)
+	_ Claimant   = (*basicMechanism)(nil)
+)
+
+// NewBasicAuthMiddleware returns an AuthcMiddleware that authenticates
+// requests with `Authorization: Basic <credentials>` (RFC 7617)
+func NewBasicAuthMiddleware(subject security.Subject, realm string, authenticator authc.Authenticator, opts ...AuthcOption) *AuthcMiddleware {
+	return NewAuthcMiddleware(subject, append(opts, WithMechanism(NewBasicMechanism(realm, authenticator)))...)
+}
+
+// NewBasicMechanism returns a Mechanism that verifies the username and password
+// carried by `Authorization: Basic` as authc.UsernamePasswordToken, since the
+// credentials are sent along with every request, no session is logged in,
+// which would replace or evict the user's own sessions, the user is stored
+// in context as Principal instead, see WithBasicAuthorization
+func NewBasicMechanism(realm string, authenticator authc.Authenticator, opts ...BasicOption) Mechanism {
+	if len(realm) == 0 {
+		realm = DefaultBasicRealm
+	}
+
+	b := &basicMechanism{
+		realm:         realm,
+		authenticator: authenticator,
+		resolver:      NewHeaderTokenResolver(authorizationHeader, basic),
+	}
+
+	for _, f := range opts {
+		f(b)
+	}
+
+	return b
+}
+
+func (b *basicMechanism) Name() string {
+	return BasicMechanismName
+}
+
+func (b *basicMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	username, password, err := b.parseCredentials(r)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	user, err := b.authenticator.Authenticate(r.Context(), authc.NewUsernamePasswordToken(username, password))
+	if err != nil {
+		return r.Context(), err
+	}
+
+	principal := &Principal{Name: user.Principal()}
+	if b.authorization != nil {
+		if principal.Roles, err = b.authorization.LoadRoles(r.Context(), user); err != nil {
+			return r.Context(), err
+		}
+
+		if principal.Authorities, err = b.authorization.LoadAuthorities(r.Context(), user); err != nil {
+			return r.Context(), err
+		}
+	}
+
+	return ContextWithPrincipal(r.Context(), principal), nil
+}
+
+func (b *basicMechanism) Claim(r *http.Request) (string, bool) {
+	username, _, err := b.parseCredentials(r)
+	return username, err == nil
+}
+
+func (b *basicMechanism) Challenge(w http.ResponseWriter, _ *http.Request, _ error) {
+	w.Header().Add(wwwAuthenticateHeader,
+		basic+" realm="+quoteString(b.realm)+`, charset="UTF-8"`)
+}
+
+func (b *basicMechanism) parseCredentials(r *http.Request) (string, string, error) {
+	encoded, err := b.resolver.Resolve(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", authc.ErrInvalidToken
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || len(username) == 0 {
+		return "", "", authc.ErrInvalidToken
+	}
+
+	return username, password, nil
+}
+
+// quoteString formats s as an HTTP quoted-string
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// WithBasicAuthorization loads the roles and authorities of the Principal
+// from the specified realm, which is usually the one of the Subject
+func WithBasicAuthorization(realm authz.Realm) BasicOption {
+	return func(b *basicMechanism) {
+		b.authorization = realm
+	}
+}
@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type (
+	// countingAuthenticator counts the credentials it verifies
+	countingAuthenticator struct {
+		authc.Authenticator
+		calls int
+	}
+)
+
+func (a *countingAuthenticator) Authenticate(ctx context.Context, token authc.Token) (authc.UserDetails, error) {
+	a.calls++
+	return a.Authenticator.Authenticate(ctx, token)
+}
+
+func TestBasicAuth(t *testing.T) {
+	subject := newFakeSubject()
+	authenticator := authc.NewAuthenticator(passwordRealm{"archer": "123"})
+
+	var principal *Principal
+	handler := NewBasicAuthMiddleware(subject, "admin", authenticator).Handle(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, w.Header().Get(wwwAuthenticateHeader))
+
+	r.SetBasicAuth("archer", "456")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r.Header.Set(authorizationHeader, "Basic !!!")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r.SetBasicAuth("archer", "123")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(wwwAuthenticateHeader))
+	assert.NotNil(t, principal)
+	assert.Equal(t, "archer", principal.Name)
+}
+
+func TestBasicAuthSessions(t *testing.T) {
+	realm := passwordRealm{"archer": "123"}
+	subject := newShieldSubject(realm)
+	admin := authz.NewRole("admin")
+
+	handler := NewAuthcMiddleware(subject, WithMechanism(
+		NewBasicMechanism("", authc.NewAuthenticator(realm), WithBasicAuthorization(grantRealm{roles: map[string][]authz.Role{"archer": {admin}}})),
+	)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		_, err := subject.Session(r.Context())
+		assert.Error(t, err)
+		assert.True(t, NewPrincipalSubject(subject).HasRole(r.Context(), admin))
+	})
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+
+	// no session is logged in, so the user's own one is never replaced
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("archer", "123")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.True(t, subject.Authenticated(ctx))
+	own, err := subject.Session(ctx)
+	assert.NoError(t, err)
+	expired, err := own.Expired(ctx)
+	assert.NoError(t, err)
+	assert.False(t, expired)
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"net/http"
+)
+
+type (
+	bearerMechanism struct {
+		resolver TokenResolver
+	}
+)
+
+// BearerMechanismName is the name of the bearer Mechanism
+const BearerMechanismName = "bearer"
+
+var (
+	_ Mechanism  = (*bearerMechanism)(nil)
+	_ Challenger = (*bearerMechanism)(nil)
+)
+
+// NewBearerMechanism returns a Mechanism that logs in the
+// token resolved by the specified resolver as authc.BearerToken
+func NewBearerMechanism(resolver TokenResolver) Mechanism {
+	return &bearerMechanism{resolver: resolver}
+}
+
+func (b *bearerMechanism) Name() string {
+	return BearerMechanismName
+}
+
+func (b *bearerMechanism) Authenticate(r *http.Request, subject security.Subject) (context.Context, error) {
+	value, err := b.resolver.Resolve(r)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	return subject.Login(r.Context(), authc.NewBearerToken(value))
+}
+
+// Challenge adds an RFC 6750 Bearer challenge
+func (b *bearerMechanism) Challenge(w http.ResponseWriter, _ *http.Request, err error) {
+	// the token is not to blame
+	if isUnavailable(err) {
+		return
+	}
+
+	code, description := EvalBearerError(err)
+	challengeBearer(w, code, description, "")
+}
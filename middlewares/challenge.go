@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"errors"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/semgt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// InvalidToken is the RFC 6750 error code used when the access
+	// token is expired, revoked, malformed, or invalid otherwise
+	InvalidToken = "invalid_token"
+	// InsufficientScope is the RFC 6750 error code used when the request
+	// requires higher privileges than provided by the access token
+	InsufficientScope = "insufficient_scope"
+)
+
+// EvalBearerError maps err to an RFC 6750 error code and description, the
+// code is empty if the request lacks credentials, in which case
+// the challenge should not carry any error information
+func EvalBearerError(err error) (string, string) {
+	if err == nil || errors.Is(err, ErrCredentialsNotFound) {
+		return "", ""
+	}
+
+	if errors.Is(err, jwt.ErrExpired) {
+		return InvalidToken, "The access token expired"
+	}
+
+	if errors.Is(err, ErrTokenInactive) {
+		return InvalidToken, "The access token is inactive"
+	}
+
+	if errors.Is(err, authc.ErrInvalidToken) {
+		return InvalidToken, "The access token is malformed"
+	}
+
+	if errors.Is(err, semgt.ErrExpired) {
+		return InvalidToken, "The session expired"
+	}
+
+	if errors.Is(err, semgt.ErrReplaced) {
+		return InvalidToken, "The session was replaced by another login"
+	}
+
+	if errors.Is(err, semgt.ErrOverflow) {
+		return InvalidToken, "The session was evicted by newer logins"
+	}
+
+	return InvalidToken, "The access token is invalid"
+}
+
+// challengeBearer adds a Bearer challenge, unless another
+// bearer Mechanism of the same request did it already
+func challengeBearer(w http.ResponseWriter, code string, description string, scope string) {
+	for _, challenge := range w.Header().Values(wwwAuthenticateHeader) {
+		if strings.HasPrefix(challenge, bearer) {
+			return
+		}
+	}
+
+	params := make([]string, 0, 3)
+	if len(code) > 0 {
+		params = append(params, "error="+quoteString(code))
+	}
+
+	if len(description) > 0 {
+		params = append(params, "error_description="+quoteString(description))
+	}
+
+	if len(scope) > 0 {
+		params = append(params, "scope="+quoteString(scope))
+	}
+
+	challenge := bearer
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	w.Header().Add(wwwAuthenticateHeader, challenge)
+}
+
+// isBearerMechanism returns true if the named Mechanism
+// authenticates requests with bearer access tokens
+func isBearerMechanism(name string) bool {
+	switch name {
+	case BearerMechanismName, JWTMechanismName, IntrospectionMechanismName:
+		return true
+	default:
+		return false
+	}
+}
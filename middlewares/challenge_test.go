@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signHS256(secret []byte, claims jwt.Claims) string {
+	header, _ := json.Marshal(jwt.Header{Algorithm: jwt.HS256, Type: "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestBearerChallenge(t *testing.T) {
+	secret := []byte("secret")
+	subject := newFakeSubject()
+	verifier := jwt.NewVerifier(jwt.StaticKeys{jwt.NewHS256Key("", secret)})
+	authzm := NewAuthzMiddleware(subject, WithRouteRegistry(
+		pattern.NewRouteRegistry().
+			AntMatches("/admin/**").HasRole(authz.NewRole("admin")).And().
+			AntMatches("/orders").HasAnyAuthority(authz.NewAuthority("orders:write")),
+	))
+	handler := NewAuthcMiddleware(subject, WithMechanism(NewJWTMechanism(verifier))).
+		Handle(authzm.Handle(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name      string
+		token     string
+		code      int
+		challenge string
+	}{
+		{"missing", "", http.StatusUnauthorized, `Bearer`},
+		{"malformed", "garbage", http.StatusUnauthorized,
+			`Bearer error="invalid_token", error_description="The access token is malformed"`},
+		{"expired", signHS256(secret, jwt.Claims{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized,
+			`Bearer error="invalid_token", error_description="The access token expired"`},
+		{"insufficient", signHS256(secret, jwt.Claims{"sub": "alice", "scope": "orders:read"}), http.StatusForbidden,
+			`Bearer error="insufficient_scope", error_description="The request requires higher privileges", scope="orders:write"`},
+		{"granted", signHS256(secret, jwt.Claims{"sub": "alice", "scope": "orders:write"}), http.StatusOK, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if len(tc.token) > 0 {
+			r.Header.Set(authorizationHeader, bearer+" "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+		assert.Equal(t, tc.challenge, w.Header().Get(wwwAuthenticateHeader), tc.name)
+	}
+
+	// more scope would not grant roles
+	r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	r.Header.Set(authorizationHeader, bearer+" "+signHS256(secret, jwt.Claims{"sub": "alice", "scope": "orders:write"}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get(wwwAuthenticateHeader))
+}
+
+func TestBearerChallengeKeysUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	verifier := jwt.NewVerifier(jwt.NewJWKS(server.URL))
+	handler := NewAuthcMiddleware(newFakeSubject(), WithMechanism(NewJWTMechanism(verifier))).
+		Handle(func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set(authorizationHeader, bearer+" "+signHS256([]byte("secret"), jwt.Claims{"sub": "alice"}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get(wwwAuthenticateHeader))
+	assert.NotContains(t, w.Body.String(), server.URL)
+	assert.NotContains(t, w.Body.String(), "500")
+}
@@ -0,0 +1,287 @@
+package middlewares
+
+import (
+	"encoding/json"
+	ant "github.com/shrinex/shield-web/pattern"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type (
+	// EntryPoint starts authentication of requests that failed to
+	// authenticate, it can be used wherever an unauthorized handler is
+	// expected, see WithUnauthorizedHandler
+	EntryPoint func(http.ResponseWriter, *http.Request, error)
+
+	EntryPointOption func(*negotiatingEntryPoint)
+
+	negotiatingEntryPoint struct {
+		matcher  ant.Matcher
+		mappings []entryPointMapping
+		browser  EntryPoint
+		api      EntryPoint
+		problem  EntryPoint
+	}
+
+	entryPointMapping struct {
+		pattern    string
+		entryPoint EntryPoint
+	}
+
+	// problemDetails is the body of RFC 9457, formerly RFC 7807
+	problemDetails struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance,omitempty"`
+	}
+)
+
+const (
+	// ReturnURLParameter is the query parameter carrying the URL
+	// to return to once the user logged in, see ReturnURL
+	ReturnURLParameter = "return_to"
+
+	htmlContentType    = "text/html"
+	xhtmlContentType   = "application/xhtml+xml"
+	problemContentType = "application/problem+json"
+
+	requestedWithHeader = "X-Requested-With"
+	xmlHTTPRequest      = "XMLHttpRequest"
+)
+
+var (
+	// JSONEntryPoint writes the code and message as JSON, which
+	// is the default of middlewares authenticating requests
+	JSONEntryPoint EntryPoint = defaultUnauthorizedHandler
+)
+
+// NewEntryPoint returns an EntryPoint that tries the patterns specified by
+// WithPatternEntryPoint in order first, then negotiates with the client:
+// browsers navigating to a page are redirected to the login page if one
+// specified, clients accepting application/problem+json get problem
+// details, and the others get JSONEntryPoint
+func NewEntryPoint(opts ...EntryPointOption) EntryPoint {
+	e := &negotiatingEntryPoint{}
+
+	for _, f := range opts {
+		f(e)
+	}
+
+	if e.matcher == nil {
+		e.matcher = ant.NewMatcher()
+	}
+
+	if e.api == nil {
+		e.api = JSONEntryPoint
+	}
+
+	if e.browser == nil {
+		e.browser = e.api
+	}
+
+	if e.problem == nil {
+		e.problem = ProblemEntryPoint
+	}
+
+	return e.commence
+}
+
+func (e *negotiatingEntryPoint) commence(w http.ResponseWriter, r *http.Request, err error) {
+	for _, mapping := range e.mappings {
+		if e.matcher.Matches(mapping.pattern, r.URL.Path) {
+			mapping.entryPoint(w, r, err)
+			return
+		}
+	}
+
+	switch {
+	case prefersHTML(r):
+		e.browser(w, r, err)
+	case prefersProblem(r):
+		e.problem(w, r, err)
+	default:
+		e.api(w, r, err)
+	}
+}
+
+// NewRedirectEntryPoint returns an EntryPoint that redirects to the specified
+// login page, the URL of GET requests is saved as ReturnURLParameter, the
+// login page must not require authentication, or it redirects forever,
+// throttled clients get the status instead, which a redirect would hide
+func NewRedirectEntryPoint(loginURL string) EntryPoint {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if _, _, ok := evalThrottle(err); ok {
+			defaultUnauthorizedHandler(w, r, err)
+			return
+		}
+
+		detailAuthLog(r, err.Error())
+
+		target, perr := url.Parse(loginURL)
+		if perr != nil {
+			log.Printf("parse login url failed: %s\n", perr.Error())
+			defaultUnauthorizedHandler(w, r, err)
+			return
+		}
+
+		// the other methods can not be replayed by a redirect,
+		// and 303 makes browsers get the login page
+		code := http.StatusSeeOther
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusFound
+			query := target.Query()
+			query.Set(ReturnURLParameter, r.URL.RequestURI())
+			target.RawQuery = query.Encode()
+		}
+
+		http.Redirect(w, r, target.String(), code)
+	}
+}
+
+// ProblemEntryPoint writes application/problem+json as defined by RFC 9457
+func ProblemEntryPoint(w http.ResponseWriter, r *http.Request, err error) {
+	// log first
+	detailAuthLog(r, err.Error())
+
+	code := evalStatus(w, err)
+
+	bytes, err := json.Marshal(problemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   evalMessage(err),
+		Instance: r.URL.Path,
+	})
+	if err != nil {
+		log.Printf("json marshal failed: %s\n", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(code)
+
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Printf("write body failed: %s\n", err.Error())
+		return
+	}
+}
+
+// ReturnURL returns the URL saved by NewRedirectEntryPoint, or fallback if
+// none or if it is not a local path, which may be an open redirect attempt
+func ReturnURL(r *http.Request, fallback string) string {
+	returnURL := r.URL.Query().Get(ReturnURLParameter)
+	if !isLocalURL(returnURL) {
+		return fallback
+	}
+
+	return returnURL
+}
+
+func isLocalURL(s string) bool {
+	// "//host" and "/\host" are treated as another host by browsers
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return false
+	}
+
+	u, err := url.Parse(s)
+	return err == nil && len(u.Scheme) == 0 && len(u.Host) == 0
+}
+
+// prefersHTML tells if the request is a navigation of browsers,
+// which ask for pages, while scripts mostly ask for */*
+func prefersHTML(r *http.Request) bool {
+	if r.Header.Get(requestedWithHeader) == xmlHTTPRequest {
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	html := acceptQuality(accept, htmlContentType)
+	if xhtml := acceptQuality(accept, xhtmlContentType); xhtml > html {
+		html = xhtml
+	}
+
+	return html > 0 &&
+		html >= acceptQuality(accept, jsonContentType) &&
+		html >= acceptQuality(accept, problemContentType)
+}
+
+func prefersProblem(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	problem := acceptQuality(accept, problemContentType)
+
+	return problem > 0 && problem >= acceptQuality(accept, jsonContentType)
+}
+
+// acceptQuality returns the quality of the media type listed explicitly in
+// the Accept header, wildcards are ignored since every client accepts */*
+func acceptQuality(accept string, mediaType string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || typ != mediaType {
+			continue
+		}
+
+		q, ok := params["q"]
+		if !ok {
+			return 1
+		}
+
+		quality, err := strconv.ParseFloat(q, 64)
+		if err != nil {
+			return 0
+		}
+
+		return quality
+	}
+
+	return 0
+}
+
+// WithLoginPage redirects browsers to the specified login page, see NewRedirectEntryPoint
+func WithLoginPage(loginURL string) EntryPointOption {
+	return WithBrowserEntryPoint(NewRedirectEntryPoint(loginURL))
+}
+
+// WithBrowserEntryPoint specifies the EntryPoint of browsers navigating to a page
+func WithBrowserEntryPoint(entryPoint EntryPoint) EntryPointOption {
+	return func(e *negotiatingEntryPoint) {
+		e.browser = entryPoint
+	}
+}
+
+// WithAPIEntryPoint specifies the EntryPoint of clients
+// other than browsers, which is JSONEntryPoint by default
+func WithAPIEntryPoint(entryPoint EntryPoint) EntryPointOption {
+	return func(e *negotiatingEntryPoint) {
+		e.api = entryPoint
+	}
+}
+
+// WithProblemEntryPoint specifies the EntryPoint of clients that
+// accept application/problem+json, which is ProblemEntryPoint by default
+func WithProblemEntryPoint(entryPoint EntryPoint) EntryPointOption {
+	return func(e *negotiatingEntryPoint) {
+		e.problem = entryPoint
+	}
+}
+
+// WithPatternEntryPoint uses the specified EntryPoint for paths matching
+// the pattern regardless of the client, patterns are tried in order
+func WithPatternEntryPoint(pattern string, entryPoint EntryPoint) EntryPointOption {
+	return func(e *negotiatingEntryPoint) {
+		e.mappings = append(e.mappings, entryPointMapping{pattern: pattern, entryPoint: entryPoint})
+	}
+}
+
+func WithEntryPointMatcher(matcher ant.Matcher) EntryPointOption {
+	return func(e *negotiatingEntryPoint) {
+		e.matcher = matcher
+	}
+}
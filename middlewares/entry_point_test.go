@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"github.com/shrinex/shield/authc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEntryPoint(t *testing.T) {
+	entryPoint := NewEntryPoint(
+		WithLoginPage("/signin?lang=zh"),
+		WithPatternEntryPoint("/admin/**", ProblemEntryPoint),
+	)
+
+	commence := func(method string, target string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for name, value := range header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		entryPoint(w, r, authc.ErrUnauthenticated)
+		return w
+	}
+
+	const navigation = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	// browsers are redirected with the return url
+	w := commence(http.MethodGet, "/orders?page=2", map[string]string{"Accept": navigation})
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/signin", location.Path)
+	assert.Equal(t, "zh", location.Query().Get("lang"))
+	assert.Equal(t, "/orders?page=2", location.Query().Get(ReturnURLParameter))
+
+	// forms can not be replayed
+	w = commence(http.MethodPost, "/orders", map[string]string{"Accept": navigation})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/signin?lang=zh", w.Header().Get("Location"))
+
+	// scripts get json
+	w = commence(http.MethodGet, "/orders", map[string]string{"Accept": navigation, "X-Requested-With": "XMLHttpRequest"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = commence(http.MethodGet, "/orders", map[string]string{"Accept": "*/*"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = commence(http.MethodGet, "/orders", map[string]string{"Accept": "application/json, text/html;q=0.5"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEqual(t, problemContentType, w.Header().Get("Content-Type"))
+
+	// problem details if asked for
+	w = commence(http.MethodGet, "/orders", map[string]string{"Accept": "application/problem+json"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	var problem problemDetails
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, http.StatusUnauthorized, problem.Status)
+	assert.Equal(t, "请先登录", problem.Detail)
+	assert.Equal(t, "/orders", problem.Instance)
+
+	// patterns win over negotiation
+	w = commence(http.MethodGet, "/admin/users", map[string]string{"Accept": navigation})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	// browsers are not redirected once throttled
+	for err, code := range map[error]int{
+		&ThrottleError{err: ErrTooManyAttempts, RetryAfter: 30 * time.Second}: http.StatusTooManyRequests,
+		&ThrottleError{err: ErrAccountLocked, RetryAfter: time.Minute}:        http.StatusLocked,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Header.Set("Accept", navigation)
+		w = httptest.NewRecorder()
+		entryPoint(w, r, err)
+		assert.Equal(t, code, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+		assert.NotEmpty(t, w.Header().Get(retryAfterHeader))
+	}
+}
+
+func TestReturnURL(t *testing.T) {
+	for returnURL, expected := range map[string]string{
+		"":                     "/",
+		"/orders?page=2":       "/orders?page=2",
+		"https://evil.example": "/",
+		"//evil.example/":      "/",
+		"/\\evil.example/":     "/",
+		"orders":               "/",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/login?"+url.Values{ReturnURLParameter: {returnURL}}.Encode(), nil)
+		assert.Equal(t, expected, ReturnURL(r, "/"), returnURL)
+	}
+}
@@ -0,0 +1,227 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"github.com/shrinex/shield/semgt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// AuthEventType tells what happened in an AuthEvent
+	AuthEventType string
+
+	// AuthEvent is published on authentication outcomes and session
+	// lifecycle changes, e.g. to feed a SIEM
+	AuthEvent struct {
+		Type AuthEventType
+		// Principal is the user concerned, empty if unknown
+		Principal string
+		// Mechanism is the way the user authenticated, e.g. bearer or form
+		Mechanism string
+		// Err is the reason of failures
+		Err error
+		// IP is the client IP
+		IP string
+		// Method is the request method
+		Method string
+		// Path is the request path
+		Path string
+		// UserAgent is the User-Agent header
+		UserAgent string
+		// Time is the time the event happened
+		Time time.Time
+	}
+
+	// AuthEventListener receives AuthEvent(s), it must not retain
+	// the event after returning unless it is an async listener
+	AuthEventListener func(context.Context, *AuthEvent)
+
+	EventPublisherOption func(*EventPublisher)
+
+	// EventPublisher dispatches AuthEvent(s) to listeners, synchronous
+	// listeners are called on the request goroutine, while each async
+	// listener has a goroutine and a buffer of its own, events are
+	// dropped once the buffer is full, so that a slow listener
+	// never holds requests back
+	EventPublisher struct {
+		listeners []AuthEventListener
+		async     []*asyncListener
+		buffer    int
+		mu        sync.RWMutex
+		closed    bool
+		wg        sync.WaitGroup
+	}
+
+	asyncListener struct {
+		listener AuthEventListener
+		events   chan asyncEvent
+	}
+
+	asyncEvent struct {
+		ctx   context.Context
+		event *AuthEvent
+	}
+
+	// detachedContext keeps the values of a request context, but is never
+	// canceled, so that async listeners outlive the request
+	detachedContext struct {
+		context.Context
+	}
+)
+
+const (
+	AuthenticationSucceeded AuthEventType = "authentication_succeeded"
+	AuthenticationFailed    AuthEventType = "authentication_failed"
+	SessionExpired          AuthEventType = "session_expired"
+	SessionReplaced         AuthEventType = "session_replaced"
+	LoggedOut               AuthEventType = "logged_out"
+
+	// DefaultEventBuffer is the buffer size of every async listener
+	DefaultEventBuffer = 1024
+
+	// FormMechanismName is the mechanism of events published by FormLoginMiddleware
+	FormMechanismName = "form"
+	// OIDCMechanismName is the mechanism of events published by OIDCLoginMiddleware
+	OIDCMechanismName = "oidc"
+	// RefreshMechanismName is the mechanism of events published by RefreshMiddleware
+	RefreshMechanismName = "refresh_token"
+)
+
+// NewEventPublisher returns an EventPublisher without listeners
+func NewEventPublisher(opts ...EventPublisherOption) *EventPublisher {
+	p := &EventPublisher{}
+
+	for _, f := range opts {
+		f(p)
+	}
+
+	if p.buffer <= 0 {
+		p.buffer = DefaultEventBuffer
+	}
+
+	return p
+}
+
+// Subscribe registers a listener called synchronously, it must
+// be called before the publisher is shared between goroutines
+func (p *EventPublisher) Subscribe(listener AuthEventListener) *EventPublisher {
+	p.listeners = append(p.listeners, listener)
+	return p
+}
+
+// SubscribeAsync registers a listener called on a goroutine of its own, it
+// must be called before the publisher is shared between goroutines
+func (p *EventPublisher) SubscribeAsync(listener AuthEventListener) *EventPublisher {
+	l := &asyncListener{listener: listener, events: make(chan asyncEvent, p.buffer)}
+	p.async = append(p.async, l)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for e := range l.events {
+			l.listener(e.ctx, e.event)
+		}
+	}()
+
+	return p
+}
+
+// Publish dispatches the event, a nil EventPublisher publishes nothing
+func (p *EventPublisher) Publish(ctx context.Context, event *AuthEvent) {
+	if p == nil {
+		return
+	}
+
+	for _, listener := range p.listeners {
+		listener(ctx, event)
+	}
+
+	if len(p.async) == 0 {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	detached := detachedContext{Context: ctx}
+	for _, l := range p.async {
+		// listeners must not see modifications made by each other
+		copied := *event
+		select {
+		case l.events <- asyncEvent{ctx: detached, event: &copied}:
+		default:
+			log.Printf("drop auth event %s of %s: listener too slow\n", event.Type, event.Principal)
+		}
+	}
+}
+
+// Close waits for async listeners to drain their buffers,
+// events published after Close reach synchronous listeners only
+func (p *EventPublisher) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, l := range p.async {
+			close(l.events)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// NewAuthEvent returns an AuthEvent carrying the request metadata
+func NewAuthEvent(r *http.Request, typ AuthEventType, principal string, mechanism string, err error) *AuthEvent {
+	return &AuthEvent{
+		Type:      typ,
+		Principal: principal,
+		Mechanism: mechanism,
+		Err:       err,
+		IP:        RemoteIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		UserAgent: r.UserAgent(),
+		Time:      nowFunc(),
+	}
+}
+
+// evalFailure returns the event type of an authentication failure,
+// telling sessions ended by the server apart from wrong credentials
+func evalFailure(err error) AuthEventType {
+	if errors.Is(err, semgt.ErrExpired) {
+		return SessionExpired
+	}
+
+	if errors.Is(err, semgt.ErrReplaced) || errors.Is(err, semgt.ErrOverflow) {
+		return SessionReplaced
+	}
+
+	return AuthenticationFailed
+}
+
+// WithEventBuffer specifies the buffer size of every async listener
+func WithEventBuffer(size int) EventPublisherOption {
+	return func(p *EventPublisher) {
+		p.buffer = size
+	}
+}
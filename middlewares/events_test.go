@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventPublisher(t *testing.T) {
+	var mu sync.Mutex
+	var sync, async []AuthEventType
+
+	events := NewEventPublisher().
+		Subscribe(func(_ context.Context, event *AuthEvent) {
+			sync = append(sync, event.Type)
+		}).
+		SubscribeAsync(func(ctx context.Context, event *AuthEvent) {
+			// async listeners outlive the request
+			assert.NoError(t, ctx.Err())
+			mu.Lock()
+			defer mu.Unlock()
+			async = append(async, event.Type)
+		})
+
+	secret := []byte("secret")
+	verifier := jwt.NewVerifier(jwt.StaticKeys{jwt.NewHS256Key("", secret)})
+	authc := NewAuthcMiddleware(newFakeSubject(), WithMechanism(NewJWTMechanism(verifier)), WithAuthcEvents(events)).
+		Handle(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+	var principals []string
+	events.Subscribe(func(_ context.Context, event *AuthEvent) {
+		principals = append(principals, event.Principal)
+	})
+
+	serve := func(token string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil).WithContext(ctx)
+		if len(token) > 0 {
+			r.Header.Set(authorizationHeader, "Bearer "+token)
+		}
+		authc(httptest.NewRecorder(), r)
+	}
+
+	serve(signHS256(secret, jwt.Claims{"sub": "alice"}))
+	serve(signHS256([]byte("forged"), jwt.Claims{"sub": "alice"}))
+	serve("")
+
+	form := NewFormLoginMiddleware(newShieldSubject(passwordRealm{"archer": "123"}), WithLoginEvents(events)).
+		Handle(func(w http.ResponseWriter, r *http.Request) {})
+	for _, password := range []string{"456", "123"} {
+		r := httptest.NewRequest(http.MethodPost, DefaultLoginPath, strings.NewReader("username=archer&password="+password))
+		r.Header.Set("Content-Type", formContentType)
+		form(httptest.NewRecorder(), r)
+	}
+
+	expected := []AuthEventType{
+		AuthenticationSucceeded,
+		AuthenticationFailed,
+		AuthenticationFailed,
+		AuthenticationFailed,
+		AuthenticationSucceeded,
+	}
+	assert.Equal(t, expected, sync)
+	assert.Equal(t, []string{"alice", "", "", "archer", "archer"}, principals)
+
+	events.Close()
+	assert.Equal(t, expected, async)
+
+	// nothing reaches async listeners after Close
+	events.Publish(context.Background(), &AuthEvent{Type: LoggedOut})
+	assert.Len(t, sync, len(expected)+1)
+	assert.Len(t, async, len(expected))
+
+	var nilPublisher *EventPublisher
+	nilPublisher.Publish(context.Background(), &AuthEvent{Type: LoggedOut, Time: time.Now()})
+}
@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Fingerprinter derives a fingerprint of the client from the request,
+	// which is expected to stay the same during a session
+	Fingerprinter func(*http.Request) string
+
+	// BindingPolicy tells what to do with requests whose
+	// fingerprint differs from the one bound to the session
+	BindingPolicy int
+)
+
+const (
+	// RejectMismatch rejects the request, the session remains valid
+	RejectMismatch BindingPolicy = iota
+	// ReauthenticateMismatch rejects the request and logs the session out
+	ReauthenticateMismatch
+	// LogMismatch logs the request and lets it through, handlers
+	// can check FingerprintMismatched to react to it
+	LogMismatch
+)
+
+// IPSubnetFingerprint returns a Fingerprinter of the subnet of RemoteIP,
+// with the specified prefix lengths, e.g. 24 and 64, so that clients
+// moving within their network keep their sessions
+func IPSubnetFingerprint(v4Bits int, v6Bits int) Fingerprinter {
+	return func(r *http.Request) string {
+		ip := net.ParseIP(RemoteIP(r))
+		if ip == nil {
+			return RemoteIP(r)
+		}
+
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(v4Bits, 8*net.IPv4len)).String()
+		}
+
+		return ip.Mask(net.CIDRMask(v6Bits, 8*net.IPv6len)).String()
+	}
+}
+
+// UserAgentFingerprint is a Fingerprinter of the User-Agent header
+func UserAgentFingerprint(r *http.Request) string {
+	return r.UserAgent()
+}
+
+// CombineFingerprints returns a Fingerprinter that
+// differs if any of the specified ones differs
+func CombineFingerprints(fingerprinters ...Fingerprinter) Fingerprinter {
+	return func(r *http.Request) string {
+		parts := make([]string, 0, len(fingerprinters))
+		for _, f := range fingerprinters {
+			parts = append(parts, f(r))
+		}
+
+		return strings.Join(parts, "\n")
+	}
+}
+
+// digestFingerprint hashes the fingerprint, so
+// that sessions never store client details
+func digestFingerprint(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:])
+}
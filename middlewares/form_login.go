@@ -0,0 +1,405 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+type (
+	FormLoginOption func(*FormLoginMiddleware)
+
+	// FormLoginMiddleware serves the login endpoint, which accepts
+	// credentials either as JSON or as a form-encoded body
+	FormLoginMiddleware struct {
+		subject           security.Subject
+		path              string
+		usernameParameter string
+		passwordParameter string
+		platformParameter string
+		loginOpts         []security.LoginOption
+		refreshTokens     *RefreshTokenService
+		throttler         *Throttler
+		fingerprinter     Fingerprinter
+		events            *EventPublisher
+		successHandler    func(http.ResponseWriter, *http.Request)
+		failureHandler    func(http.ResponseWriter, *http.Request, error)
+	}
+
+	// SessionExpiry describes when a session expires
+	SessionExpiry struct {
+		// ExpiresAt is the time the session expires, taking idle timeout into account
+		ExpiresAt time.Time `json:"expiresAt"`
+		// ExpiresIn is the number of seconds before the session expires
+		ExpiresIn int64 `json:"expiresIn"`
+	}
+)
+
+const (
+	DefaultLoginPath         = "/login"
+	DefaultUsernameParameter = "username"
+	DefaultPasswordParameter = "password"
+	DefaultPlatformParameter = "platform"
+
+	jsonContentType = "application/json"
+	maxLoginBody    = 1 << 20
+)
+
+var (
+	// ErrMalformedCredentials is returned when the login request
+	// does not carry a username or the body can not be parsed
+	ErrMalformedCredentials = fmt.Errorf("malformed credentials: %w", authc.ErrInvalidToken)
+)
+
+func NewFormLoginMiddleware(subject security.Subject, opts ...FormLoginOption) *FormLoginMiddleware {
+	m := &FormLoginMiddleware{subject: subject}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.path) == 0 {
+		m.path = DefaultLoginPath
+	}
+
+	if len(m.usernameParameter) == 0 {
+		m.usernameParameter = DefaultUsernameParameter
+	}
+
+	if len(m.passwordParameter) == 0 {
+		m.passwordParameter = DefaultPasswordParameter
+	}
+
+	if len(m.platformParameter) == 0 {
+		m.platformParameter = DefaultPlatformParameter
+	}
+
+	if len(m.loginOpts) == 0 {
+		m.loginOpts = []security.LoginOption{security.WithRenewToken()}
+	}
+
+	if m.successHandler == nil {
+		m.successHandler = defaultLoginSuccessHandler(subject)
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultLoginFailureHandler
+	}
+
+	return m
+}
+
+func (m *FormLoginMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != m.path {
+			next(w, r)
+			return
+		}
+
+		m.login(w, r)
+	}
+}
+
+func (m *FormLoginMiddleware) login(w http.ResponseWriter, r *http.Request) {
+	params, err := parseParameters(w, r, m.usernameParameter, m.passwordParameter, m.platformParameter)
+	if err != nil {
+		m.fail(w, r, "", err)
+		return
+	}
+
+	username := params[m.usernameParameter]
+	if len(username) == 0 {
+		m.fail(w, r, "", ErrMalformedCredentials)
+		return
+	}
+
+	// the client IP is only counted, rather than checked before the
+	// password is, or the users sharing an IP, e.g. behind NAT,
+	// would be locked out along with an attacker
+	if m.throttler != nil {
+		if err = m.throttler.Check(r.Context(), username, ""); err != nil {
+			m.fail(w, r, username, err)
+			return
+		}
+	}
+
+	opts := m.loginOpts
+	if platform := params[m.platformParameter]; len(platform) > 0 {
+		opts = append(opts[:len(opts):len(opts)], security.WithPlatform(platform))
+	}
+
+	token := authc.NewUsernamePasswordToken(username, params[m.passwordParameter])
+	ctx, err := m.subject.Login(r.Context(), token, opts...)
+	if err != nil {
+		if m.throttler != nil && errors.Is(err, authc.ErrUnauthenticated) {
+			if terr := m.throttler.Failed(r.Context(), username, m.throttler.ClientIP(r)); terr != nil {
+				log.Printf("record failure failed: %s\n", terr.Error())
+			}
+		}
+		m.fail(w, r, username, err)
+		return
+	}
+
+	if m.throttler != nil {
+		if err = m.throttler.Succeeded(r.Context(), username); err != nil {
+			log.Printf("reset failures failed: %s\n", err.Error())
+		}
+	}
+
+	if err = BindSession(ctx, r, m.subject, m.fingerprinter); err != nil {
+		m.fail(w, r, username, err)
+		return
+	}
+
+	if m.refreshTokens != nil {
+		platform := params[m.platformParameter]
+		if len(platform) == 0 {
+			platform = security.DefaultPlatform
+		}
+
+		var refreshToken string
+		refreshToken, err = m.refreshTokens.Issue(ctx, username, platform)
+		if err != nil {
+			m.fail(w, r, username, err)
+			return
+		}
+		ctx = contextWithRefreshToken(ctx, refreshToken)
+	}
+
+	m.events.Publish(ctx, NewAuthEvent(r, AuthenticationSucceeded, username, FormMechanismName, nil))
+	m.successHandler(w, r.WithContext(ctx))
+}
+
+func (m *FormLoginMiddleware) fail(w http.ResponseWriter, r *http.Request, username string, err error) {
+	m.events.Publish(r.Context(), NewAuthEvent(r, AuthenticationFailed, username, FormMechanismName, err))
+	m.failureHandler(w, r, err)
+}
+
+// parseParameters reads the named parameters from either a JSON or a form-encoded body
+func parseParameters(w http.ResponseWriter, r *http.Request, names ...string) (map[string]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBody)
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrMalformedCredentials
+	}
+
+	params := make(map[string]string)
+	switch mediaType {
+	case jsonContentType:
+		var body map[string]any
+		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, ErrMalformedCredentials
+		}
+		for _, name := range names {
+			if s, ok := body[name].(string); ok {
+				params[name] = s
+			}
+		}
+	case formContentType:
+		if err = r.ParseForm(); err != nil {
+			return nil, ErrMalformedCredentials
+		}
+		for _, name := range names {
+			params[name] = r.PostForm.Get(name)
+		}
+	default:
+		return nil, ErrMalformedCredentials
+	}
+
+	return params, nil
+}
+
+// EvalSessionExpiry returns when the specified session expires
+func EvalSessionExpiry(ctx context.Context, subject security.Subject) (*SessionExpiry, error) {
+	session, err := subject.Session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime, err := session.StartTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := session.Timeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lastAccessTime, err := session.LastAccessTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idleTimeout, err := session.IdleTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := startTime.Add(timeout)
+	if idleExpiresAt := lastAccessTime.Add(idleTimeout); idleTimeout > 0 && idleExpiresAt.Before(expiresAt) {
+		expiresAt = idleExpiresAt
+	}
+
+	return &SessionExpiry{
+		ExpiresAt: expiresAt,
+		ExpiresIn: int64(expiresAt.Sub(nowFunc()) / time.Second),
+	}, nil
+}
+
+func defaultLoginSuccessHandler(subject security.Subject) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := subject.Session(r.Context())
+		if err != nil {
+			defaultLoginFailureHandler(w, r, err)
+			return
+		}
+
+		expiry, err := EvalSessionExpiry(r.Context(), subject)
+		if err != nil {
+			defaultLoginFailureHandler(w, r, err)
+			return
+		}
+
+		refreshToken, _ := RefreshTokenFromContext(r.Context())
+		writeJSON(w, http.StatusOK, struct {
+			Code    int32  `json:"code"`    // 错误码
+			Message string `json:"message"` // 错误信息
+			Data    any    `json:"data"`    // 会话信息
+		}{
+			Code:    http.StatusOK,
+			Message: "登录成功",
+			Data: struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refreshToken,omitempty"`
+				*SessionExpiry
+			}{
+				Token:         session.Token(),
+				RefreshToken:  refreshToken,
+				SessionExpiry: expiry,
+			},
+		})
+	}
+}
+
+func defaultLoginFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	// never dump the body, which carries the password
+	log.Printf("login failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	code := http.StatusUnauthorized
+	if throttled, retryAfter, ok := evalThrottle(err); ok {
+		code = throttled
+		w.Header().Set(retryAfterHeader, retryAfter)
+	}
+
+	message := evalMessage(err)
+	if errors.Is(err, authc.ErrUnauthenticated) {
+		message = "用户名或密码错误"
+	} else if errors.Is(err, ErrMalformedCredentials) {
+		message = "用户名或密码格式不正确"
+	}
+
+	writeJSON(w, code, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    int32(code),
+		Message: message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("json marshal failed: %s\n", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(code)
+
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Printf("write body failed: %s\n", err.Error())
+		return
+	}
+}
+
+func WithLoginPath(path string) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.path = path
+	}
+}
+
+func WithUsernameParameter(name string) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.usernameParameter = name
+	}
+}
+
+func WithPasswordParameter(name string) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.passwordParameter = name
+	}
+}
+
+func WithPlatformParameter(name string) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.platformParameter = name
+	}
+}
+
+func WithLoginOptions(opts ...security.LoginOption) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.loginOpts = append(m.loginOpts, opts...)
+	}
+}
+
+// WithRefreshTokens issues a refresh token on every successful
+// login, see RefreshTokenFromContext
+func WithRefreshTokens(service *RefreshTokenService) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.refreshTokens = service
+	}
+}
+
+// WithLoginThrottler throttles failed login attempts per username and client IP
+func WithLoginThrottler(throttler *Throttler) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.throttler = throttler
+	}
+}
+
+// WithLoginFingerprint binds the sessions to the client that logged in, see BindSession
+func WithLoginFingerprint(fingerprinter Fingerprinter) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.fingerprinter = fingerprinter
+	}
+}
+
+// WithLoginEvents publishes login outcomes to the specified publisher
+func WithLoginEvents(events *EventPublisher) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.events = events
+	}
+}
+
+func WithLoginSuccessHandler(handler func(http.ResponseWriter, *http.Request)) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.successHandler = handler
+	}
+}
+
+func WithLoginFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) FormLoginOption {
+	return func(m *FormLoginMiddleware) {
+		m.failureHandler = handler
+	}
+}
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormLogin(t *testing.T) {
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	handler := NewFormLoginMiddleware(subject).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	// other requests pass through
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, DefaultLoginPath, nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+
+	r := httptest.NewRequest(http.MethodPost, DefaultLoginPath, strings.NewReader(`{"username":"archer","password":"456"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, DefaultLoginPath, strings.NewReader(`{"username":"archer","password":"123"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Data struct {
+			Token     string `json:"token"`
+			ExpiresIn int64  `json:"expiresIn"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotEmpty(t, body.Data.Token)
+	assert.Greater(t, body.Data.ExpiresIn, int64(0))
+
+	r = httptest.NewRequest(http.MethodPost, DefaultLoginPath, strings.NewReader("username=archer&password=123&platform=mobile"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
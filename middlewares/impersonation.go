@@ -0,0 +1,495 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"log"
+	"net/http"
+	"time"
+)
+
+type (
+	// ImpersonationEventType tells what happened in an ImpersonationEvent
+	ImpersonationEventType string
+
+	// ImpersonationEvent is the audit record of a switch
+	ImpersonationEvent struct {
+		Type ImpersonationEventType
+		// Impersonator is the principal who switches
+		Impersonator string
+		// Target is the principal being impersonated
+		Target string
+		// IP is the client IP
+		IP string
+		// Time is the time the event happened
+		Time time.Time
+		// Err is the reason of an ImpersonationDenied event
+		Err error
+	}
+
+	ImpersonationOption func(*ImpersonationMiddleware)
+
+	// ImpersonationMiddleware lets principals with the required authority
+	// switch into another user's identity, the switch creates a session of
+	// the target user, which remembers the impersonator, and the exit
+	// endpoint creates a new session of the impersonator, so the realm
+	// must support PreAuthenticatedToken
+	ImpersonationMiddleware struct {
+		subject              security.Subject
+		authenticator        authc.Authenticator
+		authorization        authz.Realm
+		authority            authz.Authority
+		path                 string
+		exitPath             string
+		parameter            string
+		protectedRoles       []authz.Role
+		protectedAuthorities []authz.Authority
+		listeners            []func(context.Context, *ImpersonationEvent)
+		fingerprinter        Fingerprinter
+		successHandler       func(http.ResponseWriter, *http.Request)
+		failureHandler       func(http.ResponseWriter, *http.Request, error)
+	}
+
+	impersonatorCtxKey struct{}
+)
+
+const (
+	ImpersonationStarted ImpersonationEventType = "started"
+	ImpersonationExited  ImpersonationEventType = "exited"
+	ImpersonationDenied  ImpersonationEventType = "denied"
+
+	DefaultImpersonationPath      = "/impersonate"
+	DefaultImpersonationExitPath  = "/impersonate/exit"
+	DefaultImpersonationParameter = "username"
+
+	// ImpersonationSource is the source of PreAuthenticatedToken
+	// logged in by ImpersonationMiddleware
+	ImpersonationSource = "impersonation"
+	// ImpersonationPlatform is the platform of impersonation sessions, so
+	// that they never replace the target user's own sessions
+	ImpersonationPlatform = "impersonation"
+
+	impersonatorKey         = "shield-web:impersonator"
+	impersonatorPlatformKey = "shield-web:impersonator-platform"
+)
+
+var (
+	// ErrImpersonationDenied is returned when the impersonator lacks the required
+	// authority, or the target is protected from the impersonator
+	ErrImpersonationDenied = errors.New("impersonation denied")
+	// ErrNotImpersonating is returned when exiting a session that is not an impersonation
+	ErrNotImpersonating = errors.New("not impersonating")
+	// ErrImpersonationTargetNotFound is returned when the target can not be logged in
+	ErrImpersonationTargetNotFound = errors.New("impersonation target not found")
+)
+
+// NewImpersonationMiddleware returns an ImpersonationMiddleware, the target is
+// looked up through the specified authenticator and authorization realm,
+// usually the ones of the Subject, so that it is vetted before any
+// session is logged in, which could replace the target's own ones
+func NewImpersonationMiddleware(subject security.Subject, authenticator authc.Authenticator, authorization authz.Realm, authority authz.Authority, opts ...ImpersonationOption) *ImpersonationMiddleware {
+	m := &ImpersonationMiddleware{
+		subject:       subject,
+		authenticator: authenticator,
+		authorization: authorization,
+		authority:     authority,
+	}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.path) == 0 {
+		m.path = DefaultImpersonationPath
+	}
+
+	if len(m.exitPath) == 0 {
+		m.exitPath = DefaultImpersonationExitPath
+	}
+
+	if len(m.parameter) == 0 {
+		m.parameter = DefaultImpersonationParameter
+	}
+
+	if len(m.listeners) == 0 {
+		m.listeners = append(m.listeners, LogImpersonationEvent)
+	}
+
+	if m.successHandler == nil {
+		m.successHandler = defaultLoginSuccessHandler(subject)
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultImpersonationFailureHandler
+	}
+
+	return m
+}
+
+func (m *ImpersonationMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			switch r.URL.Path {
+			case m.path:
+				m.impersonate(w, r)
+				return
+			case m.exitPath:
+				m.exit(w, r)
+				return
+			}
+		}
+
+		next(w, r.WithContext(m.withImpersonator(r.Context())))
+	}
+}
+
+func (m *ImpersonationMiddleware) impersonate(w http.ResponseWriter, r *http.Request) {
+	impersonator, err := m.subject.UserDetails(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	params, err := parseParameters(w, r, m.parameter)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	target := params[m.parameter]
+	if len(target) == 0 {
+		m.failureHandler(w, r, ErrMalformedCredentials)
+		return
+	}
+
+	// no nested impersonation, the audit trail would be lost
+	if _, impersonating := m.impersonatorOf(r.Context()); impersonating ||
+		target == impersonator.Principal() ||
+		!m.subject.HasAuthority(r.Context(), m.authority) {
+		m.deny(w, r, impersonator.Principal(), target, ErrImpersonationDenied)
+		return
+	}
+
+	session, err := m.subject.Session(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	// the impersonator logs in again on the same platform when exiting
+	platform, _, err := session.AttributeAsString(r.Context(), security.PlatformKey)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	token := NewPreAuthenticatedToken(target, ImpersonationSource)
+	principal, err := m.lookup(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, authc.ErrUnauthenticated) {
+			err = ErrImpersonationTargetNotFound
+		}
+		m.deny(w, r, impersonator.Principal(), target, err)
+		return
+	}
+
+	if !m.permits(r.Context(), principal) {
+		m.deny(w, r, impersonator.Principal(), target, ErrImpersonationDenied)
+		return
+	}
+
+	ctx, err := m.subject.Login(r.Context(), token, security.WithRenewToken(), security.WithPlatform(ImpersonationPlatform))
+	if err != nil {
+		m.deny(w, r, impersonator.Principal(), target, err)
+		return
+	}
+
+	if err = m.remember(ctx, impersonator.Principal(), platform); err == nil {
+		err = BindSession(ctx, r, m.subject, m.fingerprinter)
+	}
+	if err != nil {
+		// the session must not outlive an impersonation that never started
+		if _, lerr := m.subject.Logout(ctx); lerr != nil {
+			log.Printf("logout impersonation failed: %s\n", lerr.Error())
+		}
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	m.emit(ctx, r, ImpersonationStarted, impersonator.Principal(), target, nil)
+	m.successHandler(w, r.WithContext(contextWithImpersonator(ctx, impersonator.Principal())))
+}
+
+func (m *ImpersonationMiddleware) exit(w http.ResponseWriter, r *http.Request) {
+	target, err := m.subject.UserDetails(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	impersonator, ok := m.impersonatorOf(r.Context())
+	if !ok {
+		m.failureHandler(w, r, ErrNotImpersonating)
+		return
+	}
+
+	session, err := m.subject.Session(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	opts := []security.LoginOption{security.WithRenewToken()}
+	platform, found, err := session.AttributeAsString(r.Context(), impersonatorPlatformKey)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if found && len(platform) > 0 {
+		opts = append(opts, security.WithPlatform(platform))
+	}
+
+	// the impersonator logs in first, so that a failure
+	// leaves the impersonation session to retry with
+	ctx, err := m.subject.Login(r.Context(), NewPreAuthenticatedToken(impersonator, ImpersonationSource), opts...)
+	if err == nil {
+		err = BindSession(ctx, r, m.subject, m.fingerprinter)
+	}
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if _, err = m.subject.Logout(r.Context()); err != nil {
+		if _, lerr := m.subject.Logout(ctx); lerr != nil {
+			log.Printf("logout impersonator failed: %s\n", lerr.Error())
+		}
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	m.emit(ctx, r, ImpersonationExited, impersonator, target.Principal(), nil)
+
+	// the impersonator may have been stored in context by the switch
+	m.successHandler(w, r.WithContext(context.WithValue(ctx, impersonatorCtxKey{}, nil)))
+}
+
+// lookup returns the target along with its roles and authorities
+func (m *ImpersonationMiddleware) lookup(ctx context.Context, token authc.Token) (*Principal, error) {
+	user, err := m.authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{Name: user.Principal()}
+	if principal.Roles, err = m.authorization.LoadRoles(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if principal.Authorities, err = m.authorization.LoadAuthorities(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return principal, nil
+}
+
+// permits returns true unless the target holds a protected
+// role or authority that the impersonator does not hold
+func (m *ImpersonationMiddleware) permits(impersonator context.Context, target *Principal) bool {
+	// otherwise impersonators could impersonate each other to cover their tracks
+	if target.hasAuthority(m.authority) {
+		return false
+	}
+
+	for _, role := range m.protectedRoles {
+		if target.hasRole(role) && !m.subject.HasRole(impersonator, role) {
+			return false
+		}
+	}
+
+	for _, authority := range m.protectedAuthorities {
+		if target.hasAuthority(authority) && !m.subject.HasAuthority(impersonator, authority) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *ImpersonationMiddleware) remember(ctx context.Context, impersonator string, platform string) error {
+	session, err := m.subject.Session(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = session.SetAttribute(ctx, impersonatorKey, impersonator); err != nil {
+		return err
+	}
+
+	if err = session.SetAttribute(ctx, impersonatorPlatformKey, platform); err != nil {
+		return err
+	}
+
+	return session.Flush(ctx)
+}
+
+func (m *ImpersonationMiddleware) impersonatorOf(ctx context.Context) (string, bool) {
+	session, err := m.subject.Session(ctx)
+	if err != nil {
+		return "", false
+	}
+
+	impersonator, found, err := session.AttributeAsString(ctx, impersonatorKey)
+	if err != nil || !found || len(impersonator) == 0 {
+		return "", false
+	}
+
+	return impersonator, true
+}
+
+func (m *ImpersonationMiddleware) withImpersonator(ctx context.Context) context.Context {
+	impersonator, ok := m.impersonatorOf(ctx)
+	if !ok {
+		return ctx
+	}
+
+	return contextWithImpersonator(ctx, impersonator)
+}
+
+func (m *ImpersonationMiddleware) deny(w http.ResponseWriter, r *http.Request, impersonator string, target string, err error) {
+	m.emit(r.Context(), r, ImpersonationDenied, impersonator, target, err)
+	m.failureHandler(w, r, err)
+}
+
+func (m *ImpersonationMiddleware) emit(ctx context.Context, r *http.Request, typ ImpersonationEventType, impersonator string, target string, err error) {
+	event := &ImpersonationEvent{
+		Type:         typ,
+		Impersonator: impersonator,
+		Target:       target,
+		IP:           RemoteIP(r),
+		Time:         nowFunc(),
+		Err:          err,
+	}
+
+	for _, listener := range m.listeners {
+		listener(ctx, event)
+	}
+}
+
+func contextWithImpersonator(ctx context.Context, impersonator string) context.Context {
+	return context.WithValue(ctx, impersonatorCtxKey{}, impersonator)
+}
+
+// ImpersonatorFromContext returns the original principal if
+// the current session is an impersonation
+func ImpersonatorFromContext(ctx context.Context) (string, bool) {
+	impersonator, ok := ctx.Value(impersonatorCtxKey{}).(string)
+	return impersonator, ok
+}
+
+// LogImpersonationEvent writes the event to the standard logger,
+// which is the listener used if none specified
+func LogImpersonationEvent(_ context.Context, event *ImpersonationEvent) {
+	if event.Err != nil {
+		log.Printf("impersonation %s: %s as %s from %s: %s\n",
+			event.Type, event.Impersonator, event.Target, event.IP, event.Err.Error())
+		return
+	}
+
+	log.Printf("impersonation %s: %s as %s from %s\n",
+		event.Type, event.Impersonator, event.Target, event.IP)
+}
+
+func defaultImpersonationFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("impersonation failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	code := http.StatusForbidden
+	message := "无权切换到该用户"
+	if errors.Is(err, ErrNotImpersonating) {
+		code = http.StatusBadRequest
+		message = "当前未切换用户"
+	} else if errors.Is(err, ErrImpersonationTargetNotFound) {
+		code = http.StatusNotFound
+		message = "用户不存在"
+	} else if errors.Is(err, ErrMalformedCredentials) {
+		code = http.StatusBadRequest
+		message = "用户名格式不正确"
+	} else if !errors.Is(err, ErrImpersonationDenied) {
+		code = http.StatusUnauthorized
+		message = evalMessage(err)
+	}
+
+	writeJSON(w, code, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    int32(code),
+		Message: message,
+	})
+}
+
+func WithImpersonationPath(path string) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.path = path
+	}
+}
+
+func WithImpersonationExitPath(path string) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.exitPath = path
+	}
+}
+
+func WithImpersonationParameter(name string) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.parameter = name
+	}
+}
+
+// WithProtectedRoles protects targets holding any of the specified roles
+// from impersonators who do not hold the same role, e.g. ADMIN
+func WithProtectedRoles(roles ...authz.Role) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.protectedRoles = append(m.protectedRoles, roles...)
+	}
+}
+
+// WithProtectedAuthorities protects targets holding any of the specified
+// authorities from impersonators who do not hold the same authority
+func WithProtectedAuthorities(authorities ...authz.Authority) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.protectedAuthorities = append(m.protectedAuthorities, authorities...)
+	}
+}
+
+// WithImpersonationListener registers an audit listener, which
+// replaces the default LogImpersonationEvent
+func WithImpersonationListener(listener func(context.Context, *ImpersonationEvent)) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.listeners = append(m.listeners, listener)
+	}
+}
+
+// WithImpersonationFingerprint binds the sessions switched to
+// the client of the impersonator, see BindSession
+func WithImpersonationFingerprint(fingerprinter Fingerprinter) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.fingerprinter = fingerprinter
+	}
+}
+
+func WithImpersonationSuccessHandler(handler func(http.ResponseWriter, *http.Request)) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.successHandler = handler
+	}
+}
+
+func WithImpersonationFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) ImpersonationOption {
+	return func(m *ImpersonationMiddleware) {
+		m.failureHandler = handler
+	}
+}
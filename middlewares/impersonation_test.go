@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/codec"
+	"github.com/shrinex/shield/security"
+	"github.com/shrinex/shield/semgt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grantRealm grants the roles and authorities registered under the principal
+type grantRealm struct {
+	roles       map[string][]authz.Role
+	authorities map[string][]authz.Authority
+}
+
+func (g grantRealm) LoadRoles(_ context.Context, user authc.UserDetails) ([]authz.Role, error) {
+	return g.roles[user.Principal()], nil
+}
+
+func (g grantRealm) LoadAuthorities(_ context.Context, user authc.UserDetails) ([]authz.Authority, error) {
+	return g.authorities[user.Principal()], nil
+}
+
+func TestImpersonation(t *testing.T) {
+	impersonate := authz.NewAuthority("impersonate")
+	admin := authz.NewRole("admin")
+
+	realm := passwordRealm{"support": "123", "alice": "", "root": "", "bob": ""}
+	authenticator := authc.NewAuthenticator(realm)
+	grants := grantRealm{
+		roles: map[string][]authz.Role{"root": {admin}},
+		authorities: map[string][]authz.Authority{
+			"support": {impersonate},
+			"bob":     {impersonate},
+		},
+	}
+	repository := semgt.NewRepository(codec.JSON, time.Hour, time.Hour)
+	registry := semgt.NewRegistry(repository)
+	subject := security.NewBuilder[*semgt.MapSession]().
+		Authenticator(authenticator).
+		Authorizer(authz.NewAuthorizer(grants)).
+		Repository(repository).
+		Registry(registry).
+		Build()
+
+	var events []*ImpersonationEvent
+	var current context.Context
+	handler := NewImpersonationMiddleware(subject, authenticator, grants, impersonate,
+		WithProtectedRoles(admin),
+		WithImpersonationListener(func(_ context.Context, event *ImpersonationEvent) {
+			events = append(events, event)
+		}),
+		WithImpersonationSuccessHandler(func(w http.ResponseWriter, r *http.Request) {
+			current = r.Context()
+			w.WriteHeader(http.StatusOK)
+		}),
+	).Handle(func(w http.ResponseWriter, r *http.Request) {
+		current = r.Context()
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	post := func(ctx context.Context, path string, body string) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", formContentType)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("support", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+
+	// unauthenticated
+	assert.Equal(t, http.StatusUnauthorized, post(context.Background(), DefaultImpersonationPath, "username=alice"))
+
+	// protected targets
+	assert.Equal(t, http.StatusForbidden, post(ctx, DefaultImpersonationPath, "username=root"))
+	assert.Equal(t, http.StatusForbidden, post(ctx, DefaultImpersonationPath, "username=bob"))
+	assert.Equal(t, http.StatusForbidden, post(ctx, DefaultImpersonationPath, "username=support"))
+	assert.Equal(t, http.StatusNotFound, post(ctx, DefaultImpersonationPath, "username=nobody"))
+	assert.Len(t, events, 4)
+	assert.Equal(t, ImpersonationDenied, events[0].Type)
+
+	// denied targets are vetted before any session is logged in
+	for _, target := range []string{"root", "bob"} {
+		sessions, err := registry.ActiveSessions(context.Background(), target)
+		assert.NoError(t, err)
+		assert.Empty(t, sessions, target)
+	}
+
+	// not impersonating
+	assert.Equal(t, http.StatusBadRequest, post(ctx, DefaultImpersonationExitPath, ""))
+
+	assert.Equal(t, http.StatusOK, post(ctx, DefaultImpersonationPath, "username=alice"))
+	assert.Equal(t, ImpersonationStarted, events[4].Type)
+	assert.Equal(t, "support", events[4].Impersonator)
+	assert.Equal(t, "alice", events[4].Target)
+
+	impersonated := current
+	user, err := subject.UserDetails(impersonated)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", user.Principal())
+
+	// the audit trail is available to handlers
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(impersonated)
+	handler(httptest.NewRecorder(), r)
+	impersonator, ok := ImpersonatorFromContext(current)
+	assert.True(t, ok)
+	assert.Equal(t, "support", impersonator)
+
+	// no nested impersonation
+	assert.Equal(t, http.StatusForbidden, post(impersonated, DefaultImpersonationPath, "username=bob"))
+
+	// the impersonation survives a failed exit, so that it can be retried
+	delete(realm, "support")
+	assert.Equal(t, http.StatusUnauthorized, post(impersonated, DefaultImpersonationExitPath, ""))
+	assert.Len(t, events, 6)
+	session, err := subject.Session(impersonated)
+	assert.NoError(t, err)
+	stored, err := repository.Read(context.Background(), session.Token())
+	assert.NoError(t, err)
+	assert.NotNil(t, stored)
+
+	realm["support"] = "123"
+	assert.Equal(t, http.StatusOK, post(impersonated, DefaultImpersonationExitPath, ""))
+	assert.Len(t, events, 7)
+	assert.Equal(t, ImpersonationExited, events[6].Type)
+	stored, err = repository.Read(context.Background(), session.Token())
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	user, err = subject.UserDetails(current)
+	assert.NoError(t, err)
+	assert.Equal(t, "support", user.Principal())
+	_, ok = ImpersonatorFromContext(current)
+	assert.False(t, ok)
+}
@@ -0,0 +1,317 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	IntrospectorOption func(*Introspector)
+
+	// Introspector validates opaque tokens against the introspection
+	// endpoint of an authorization server as defined by RFC 7662, active
+	// results are cached until the token expires, expired results are
+	// swept at most once per DefaultSweepInterval
+	Introspector struct {
+		endpoint     string
+		clientID     string
+		clientSecret string
+		client       *http.Client
+		cacheSize    int
+		mu           sync.Mutex
+		cache        map[string]*introspection
+		sweptAt      time.Time
+	}
+
+	introspection struct {
+		claims    jwt.Claims
+		expiresAt time.Time
+	}
+
+	IntrospectionOption func(*introspectionMechanism)
+
+	introspectionMechanism struct {
+		introspector     *Introspector
+		resolver         TokenResolver
+		principalClaim   string
+		authoritiesClaim string
+	}
+)
+
+const (
+	// IntrospectionMechanismName is the name of the introspection Mechanism
+	IntrospectionMechanismName = "introspection"
+
+	// ActiveClaim is the introspection response member telling whether the token is active
+	ActiveClaim = "active"
+	// UsernameClaim is the introspection response member of the human-readable identifier
+	UsernameClaim = "username"
+
+	// DefaultIntrospectionCacheSize is the number of results cached if none specified
+	DefaultIntrospectionCacheSize = 10000
+
+	maxIntrospectionBody = 1 << 20
+)
+
+var (
+	_ Mechanism  = (*introspectionMechanism)(nil)
+	_ Challenger = (*introspectionMechanism)(nil)
+
+	// ErrTokenInactive is returned when the authorization server reports the token inactive
+	ErrTokenInactive = fmt.Errorf("token inactive: %w", authc.ErrInvalidToken)
+	// ErrIntrospectionUnavailable is returned when the authorization server can not be
+	// reached or fails, the details are logged only since they reveal the endpoint
+	ErrIntrospectionUnavailable = errors.New("introspection unavailable")
+)
+
+// NewIntrospector returns an Introspector calls the specified endpoint,
+// authenticating itself with the specified client credentials
+func NewIntrospector(endpoint string, clientID string, clientSecret string, opts ...IntrospectorOption) *Introspector {
+	i := &Introspector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		cache:        make(map[string]*introspection),
+	}
+
+	for _, f := range opts {
+		f(i)
+	}
+
+	if i.client == nil {
+		i.client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if i.cacheSize <= 0 {
+		i.cacheSize = DefaultIntrospectionCacheSize
+	}
+
+	return i
+}
+
+// Introspect returns the claims of the specified token if it is
+// active, or ErrTokenInactive otherwise, the returned claims
+// must not be modified since they may be cached
+func (i *Introspector) Introspect(ctx context.Context, token string) (jwt.Claims, error) {
+	digest := hex.EncodeToString(hashToken(token))
+	if claims, ok := i.cached(digest); ok {
+		return claims, nil
+	}
+
+	claims, err := i.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if active, _ := claims[ActiveClaim].(bool); !active {
+		return nil, ErrTokenInactive
+	}
+
+	expiresAt, ok := claims.ExpiresAt()
+	if ok && !nowFunc().Before(expiresAt) {
+		return nil, ErrTokenInactive
+	}
+
+	// a token without `exp` may be revoked at any
+	// time, so it has to be introspected every time
+	if ok {
+		i.store(digest, &introspection{claims: claims, expiresAt: expiresAt})
+	}
+
+	return claims, nil
+}
+
+func (i *Introspector) introspect(ctx context.Context, token string) (jwt.Claims, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("new introspection request: %w", err)
+	}
+
+	// RFC 6749 requires client credentials to be form-encoded first
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	req.Header.Set("Content-Type", formContentType)
+	req.Header.Set("Accept", jsonContentType)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIntrospectionUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrIntrospectionUnavailable, resp.StatusCode)
+	}
+
+	var claims jwt.Claims
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxIntrospectionBody)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: decode response: %s", ErrIntrospectionUnavailable, err.Error())
+	}
+
+	return claims, nil
+}
+
+func (i *Introspector) cached(digest string) (jwt.Claims, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, ok := i.cache[digest]
+	if !ok {
+		return nil, false
+	}
+
+	if !nowFunc().Before(entry.expiresAt) {
+		delete(i.cache, digest)
+		return nil, false
+	}
+
+	return entry.claims, true
+}
+
+func (i *Introspector) store(digest string, entry *introspection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := nowFunc()
+	if now.Sub(i.sweptAt) >= DefaultSweepInterval {
+		i.sweptAt = now
+		for key, e := range i.cache {
+			if !now.Before(e.expiresAt) {
+				delete(i.cache, key)
+			}
+		}
+	}
+
+	// evicts an arbitrary result once full, so that a flood
+	// of distinct tokens can not grow the cache without bound
+	if _, ok := i.cache[digest]; !ok && len(i.cache) >= i.cacheSize {
+		for key := range i.cache {
+			delete(i.cache, key)
+			break
+		}
+	}
+
+	i.cache[digest] = entry
+}
+
+// NewIntrospectionMechanism returns a Mechanism that validates opaque
+// bearer tokens through the specified Introspector, the token owner is
+// stored in context as Principal whose authorities are mapped from `scope`,
+// and the introspection response is available to handlers through jwt.FromContext
+func NewIntrospectionMechanism(introspector *Introspector, opts ...IntrospectionOption) Mechanism {
+	m := &introspectionMechanism{introspector: introspector}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.resolver == nil {
+		m.resolver = NewBearerTokenResolver()
+	}
+
+	if len(m.authoritiesClaim) == 0 {
+		m.authoritiesClaim = DefaultAuthoritiesClaim
+	}
+
+	return m
+}
+
+func (m *introspectionMechanism) Name() string {
+	return IntrospectionMechanismName
+}
+
+func (m *introspectionMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	token, err := m.resolver.Resolve(r)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	claims, err := m.introspector.Introspect(r.Context(), token)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	// `sub` is preferred, but both are optional
+	var name string
+	if len(m.principalClaim) > 0 {
+		name = claims.String(m.principalClaim)
+	} else if name = claims.Subject(); len(name) == 0 {
+		name = claims.String(UsernameClaim)
+	}
+
+	if len(name) == 0 {
+		return r.Context(), authc.ErrInvalidToken
+	}
+
+	principal := &Principal{
+		Name:       name,
+		Attributes: claims,
+	}
+
+	for _, authority := range claims.Strings(m.authoritiesClaim) {
+		principal.Authorities = append(principal.Authorities, authz.NewAuthority(authority))
+	}
+
+	ctx := jwt.NewContext(r.Context(), claims)
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// Challenge adds an RFC 6750 Bearer challenge
+func (m *introspectionMechanism) Challenge(w http.ResponseWriter, _ *http.Request, err error) {
+	// the token is not to blame
+	if isUnavailable(err) {
+		return
+	}
+
+	code, description := EvalBearerError(err)
+	challengeBearer(w, code, description, "")
+}
+
+func WithIntrospectionHTTPClient(client *http.Client) IntrospectorOption {
+	return func(i *Introspector) {
+		i.client = client
+	}
+}
+
+// WithIntrospectionCacheSize specifies the maximum number of results cached
+func WithIntrospectionCacheSize(size int) IntrospectorOption {
+	return func(i *Introspector) {
+		i.cacheSize = size
+	}
+}
+
+func WithIntrospectionTokenResolver(resolver TokenResolver) IntrospectionOption {
+	return func(m *introspectionMechanism) {
+		m.resolver = resolver
+	}
+}
+
+// WithIntrospectionPrincipalClaim specifies the member used as principal,
+// `sub` falling back to `username` is used if none specified
+func WithIntrospectionPrincipalClaim(name string) IntrospectionOption {
+	return func(m *introspectionMechanism) {
+		m.principalClaim = name
+	}
+}
+
+func WithIntrospectionAuthoritiesClaim(name string) IntrospectionOption {
+	return func(m *introspectionMechanism) {
+		m.authoritiesClaim = name
+	}
+}
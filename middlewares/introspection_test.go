@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIntrospection(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "resource" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.NoError(t, r.ParseForm())
+		switch r.PostForm.Get("token") {
+		case "opaque":
+			writeJSON(w, http.StatusOK, map[string]any{
+				"active":   true,
+				"username": "jdoe",
+				"scope":    "orders:read orders:write",
+				"exp":      time.Now().Add(time.Hour).Unix(),
+			})
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"active": false})
+		}
+	}))
+	defer server.Close()
+
+	subject := newFakeSubject()
+	introspector := NewIntrospector(server.URL, "resource", "secret")
+	authzm := NewAuthzMiddleware(subject, WithRouteRegistry(
+		pattern.NewRouteRegistry().AnyRequests().HasAuthority(authz.NewAuthority("orders:write")),
+	))
+	handler := NewAuthcMiddleware(subject, WithMechanism(NewIntrospectionMechanism(introspector))).
+		Handle(authzm.Handle(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			assert.True(t, ok)
+			assert.Equal(t, "jdoe", principal.Name)
+		}))
+
+	for _, tc := range []struct {
+		token string
+		code  int
+	}{
+		{"opaque", http.StatusOK},
+		{"opaque", http.StatusOK},
+		{"revoked", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Header.Set(authorizationHeader, bearer+" "+tc.token)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, tc.code, w.Code, tc.token)
+	}
+
+	// the active result is cached
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// failures of the authorization server are not revealed
+	handler = NewAuthcMiddleware(subject, WithMechanism(NewIntrospectionMechanism(
+		NewIntrospector(server.URL, "resource", "wrong"),
+	))).Handle(authzm.Handle(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected call")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set(authorizationHeader, bearer+" opaque")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get(wwwAuthenticateHeader))
+	assert.NotContains(t, w.Body.String(), server.URL)
+	assert.NotContains(t, w.Body.String(), "401")
+}
+
+func TestIntrospectionCacheSize(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"active": true,
+			"sub":    "jdoe",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		})
+	}))
+	defer server.Close()
+
+	introspector := NewIntrospector(server.URL, "resource", "secret", WithIntrospectionCacheSize(2))
+	for _, token := range []string{"a", "b", "c", "d"} {
+		_, err := introspector.Introspect(context.TODO(), token)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, introspector.cache, 2)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
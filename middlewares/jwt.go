@@ -0,0 +1,138 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+)
+
+type (
+	JWTOption func(*jwtMechanism)
+
+	jwtMechanism struct {
+		verifier         *jwt.Verifier
+		resolver         TokenResolver
+		principalClaim   string
+		rolesClaim       string
+		authoritiesClaim string
+	}
+)
+
+const (
+	// DefaultRolesClaim is the claim mapped to roles if none specified
+	DefaultRolesClaim = "roles"
+	// DefaultAuthoritiesClaim is the claim mapped to authorities if none specified
+	DefaultAuthoritiesClaim = jwt.ScopeClaim
+	// JWTMechanismName is the name of the JWT Mechanism
+	JWTMechanismName = "jwt"
+)
+
+var (
+	_ Mechanism  = (*jwtMechanism)(nil)
+	_ Challenger = (*jwtMechanism)(nil)
+)
+
+// NewJWTMechanism returns a Mechanism that validates bearer JWTs locally,
+// without a session store roundtrip, the token owner is stored in
+// context as Principal, and the parsed claims are available
+// to handlers through jwt.FromContext
+func NewJWTMechanism(verifier *jwt.Verifier, opts ...JWTOption) Mechanism {
+	m := &jwtMechanism{verifier: verifier}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.resolver == nil {
+		m.resolver = NewBearerTokenResolver()
+	}
+
+	if len(m.principalClaim) == 0 {
+		m.principalClaim = jwt.SubjectClaim
+	}
+
+	if len(m.rolesClaim) == 0 {
+		m.rolesClaim = DefaultRolesClaim
+	}
+
+	if len(m.authoritiesClaim) == 0 {
+		m.authoritiesClaim = DefaultAuthoritiesClaim
+	}
+
+	return m
+}
+
+func (m *jwtMechanism) Name() string {
+	return JWTMechanismName
+}
+
+func (m *jwtMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	token, err := m.resolver.Resolve(r)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	claims, err := m.verifier.Verify(r.Context(), token)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	name := claims.String(m.principalClaim)
+	if len(name) == 0 {
+		return r.Context(), authc.ErrInvalidToken
+	}
+
+	principal := &Principal{
+		Name:       name,
+		Attributes: claims,
+	}
+
+	for _, role := range claims.Strings(m.rolesClaim) {
+		principal.Roles = append(principal.Roles, authz.NewRole(role))
+	}
+
+	for _, authority := range claims.Strings(m.authoritiesClaim) {
+		principal.Authorities = append(principal.Authorities, authz.NewAuthority(authority))
+	}
+
+	ctx := jwt.NewContext(r.Context(), claims)
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// Challenge adds an RFC 6750 Bearer challenge
+func (m *jwtMechanism) Challenge(w http.ResponseWriter, _ *http.Request, err error) {
+	// the token is not to blame
+	if isUnavailable(err) {
+		return
+	}
+
+	code, description := EvalBearerError(err)
+	challengeBearer(w, code, description, "")
+}
+
+func WithJWTTokenResolver(resolver TokenResolver) JWTOption {
+	return func(m *jwtMechanism) {
+		m.resolver = resolver
+	}
+}
+
+func WithPrincipalClaim(name string) JWTOption {
+	return func(m *jwtMechanism) {
+		m.principalClaim = name
+	}
+}
+
+func WithRolesClaim(name string) JWTOption {
+	return func(m *jwtMechanism) {
+		m.rolesClaim = name
+	}
+}
+
+func WithAuthoritiesClaim(name string) JWTOption {
+	return func(m *jwtMechanism) {
+		m.authoritiesClaim = name
+	}
+}
@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"errors"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"log"
+	"net/http"
+)
+
+type (
+	// LogoutHandler is called with the request that was authenticated,
+	// once the session is invalidated, e.g. for audit purpose, it is
+	// not called if the logout fails, or there is no session at all
+	LogoutHandler func(http.ResponseWriter, *http.Request)
+
+	LogoutOption func(*LogoutMiddleware)
+
+	// LogoutMiddleware serves the logout endpoint
+	LogoutMiddleware struct {
+		subject        security.Subject
+		path           string
+		method         string
+		cookies        []string
+		handlers       []LogoutHandler
+		events         *EventPublisher
+		successHandler func(http.ResponseWriter, *http.Request)
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+const DefaultLogoutPath = "/logout"
+
+func NewLogoutMiddleware(subject security.Subject, opts ...LogoutOption) *LogoutMiddleware {
+	m := &LogoutMiddleware{subject: subject}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.path) == 0 {
+		m.path = DefaultLogoutPath
+	}
+
+	if len(m.method) == 0 {
+		m.method = http.MethodPost
+	}
+
+	if m.successHandler == nil {
+		m.successHandler = defaultLogoutSuccessHandler
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultLogoutFailureHandler
+	}
+
+	return m
+}
+
+func (m *LogoutMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m.method || r.URL.Path != m.path {
+			next(w, r)
+			return
+		}
+
+		m.logout(w, r)
+	}
+}
+
+func (m *LogoutMiddleware) logout(w http.ResponseWriter, r *http.Request) {
+	user, uerr := m.subject.UserDetails(r.Context())
+
+	// logging out twice is not an error, while the others leave the
+	// session valid, in which case the cookies are kept to retry with
+	ctx, err := m.subject.Logout(r.Context())
+	if err != nil && !errors.Is(err, authc.ErrUnauthenticated) {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if err == nil && uerr == nil {
+		for _, handler := range m.handlers {
+			handler(w, r)
+		}
+	}
+
+	if uerr == nil {
+		mechanism, _ := MechanismFromContext(r.Context())
+		m.events.Publish(r.Context(), NewAuthEvent(r, LoggedOut, user.Principal(), mechanism, nil))
+	}
+
+	for _, name := range m.cookies {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+
+	m.successHandler(w, r.WithContext(ctx))
+}
+
+func defaultLogoutSuccessHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusOK,
+		Message: "退出成功",
+	})
+}
+
+func defaultLogoutFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("logout failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	writeJSON(w, http.StatusInternalServerError, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusInternalServerError,
+		Message: "退出失败，请稍后重试",
+	})
+}
+
+func WithLogoutPath(path string) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.path = path
+	}
+}
+
+func WithLogoutMethod(method string) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.method = method
+	}
+}
+
+func WithLogoutHandlers(handlers ...LogoutHandler) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.handlers = append(m.handlers, handlers...)
+	}
+}
+
+// WithClearCookies specifies cookies, e.g. the one carries
+// the token, to be cleared from the client on logout
+func WithClearCookies(names ...string) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.cookies = append(m.cookies, names...)
+	}
+}
+
+// WithLogoutEvents publishes logouts to the specified publisher
+func WithLogoutEvents(events *EventPublisher) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.events = events
+	}
+}
+
+func WithLogoutSuccessHandler(handler func(http.ResponseWriter, *http.Request)) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.successHandler = handler
+	}
+}
+
+func WithLogoutFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) LogoutOption {
+	return func(m *LogoutMiddleware) {
+		m.failureHandler = handler
+	}
+}
@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// brokenLogoutSubject fails to log out, like a repository being down
+type brokenLogoutSubject struct {
+	security.Subject
+}
+
+func (s brokenLogoutSubject) Logout(ctx context.Context) (context.Context, error) {
+	return ctx, errors.New("repository unavailable")
+}
+
+func TestLogout(t *testing.T) {
+	subject := newFakeSubject()
+	subject.credentials["archer"] = "123"
+
+	var logouts, handled int
+	events := NewEventPublisher().Subscribe(func(_ context.Context, event *AuthEvent) {
+		logouts++
+	})
+
+	logout := func(subject security.Subject, ctx context.Context) *httptest.ResponseRecorder {
+		handler := NewLogoutMiddleware(subject, WithClearCookies("token"), WithLogoutEvents(events),
+			WithLogoutHandlers(func(http.ResponseWriter, *http.Request) { handled++ })).Handle(nil)
+		r := httptest.NewRequest(http.MethodPost, DefaultLogoutPath, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"))
+	assert.NoError(t, err)
+
+	w := logout(subject, ctx)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, 1, logouts)
+	assert.Equal(t, 1, handled)
+
+	// logging out twice is not an error
+	w = logout(subject, context.Background())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, logouts)
+	assert.Equal(t, 1, handled)
+
+	// the session is still valid, so the cookies are kept
+	w = logout(brokenLogoutSubject{subject}, ctx)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Equal(t, 1, logouts)
+	assert.Equal(t, 1, handled)
+}
@@ -0,0 +1,453 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/security"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// MFAEnrollment is the second factor enrolled by a principal
+	MFAEnrollment struct {
+		// Secret is the base32 encoded TOTP secret, empty until confirmed
+		Secret string
+		// PendingSecret is the secret enrolled but not confirmed yet, which
+		// replaces Secret once a code of it has been verified
+		PendingSecret string
+		// LastStep is the time step of the last accepted
+		// code, which can not be used again
+		LastStep int64
+	}
+
+	// MFAStore is responsible for storing MFA enrollments, which
+	// should be encrypted at rest by implementations
+	MFAStore interface {
+		// Load returns the enrollment of the specified principal, or nil if none
+		Load(context.Context, string) (*MFAEnrollment, error)
+		// Save creates or updates the enrollment of the specified principal
+		Save(context.Context, string, *MFAEnrollment) error
+		// Accept atomically records the time step of a code of the specified
+		// secret as the last accepted one, promoting the secret if pending,
+		// ok is false if a code of the step or a later one has been
+		// accepted, or if the secret is no longer enrolled
+		Accept(ctx context.Context, principal string, secret string, step int64) (bool, error)
+	}
+
+	// MemoryMFAStore is an MFAStore backed by a map
+	MemoryMFAStore struct {
+		mu          sync.Mutex
+		enrollments map[string]*MFAEnrollment
+	}
+
+	MFAOption func(*MFAMiddleware)
+
+	// MFAMiddleware serves the TOTP enrollment and verification endpoints
+	// of authenticated users, a successful verification is recorded in
+	// the session, see pattern.RouteRegistry.RequiresRecentMFA
+	MFAMiddleware struct {
+		subject        security.Subject
+		store          MFAStore
+		totp           *TOTP
+		issuer         string
+		enrollPath     string
+		verifyPath     string
+		parameter      string
+		reenrollAge    time.Duration
+		throttler      *Throttler
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+)
+
+const (
+	DefaultMFAEnrollPath    = "/mfa/enroll"
+	DefaultMFAVerifyPath    = "/mfa/verify"
+	DefaultMFACodeParameter = "code"
+	DefaultMFAIssuer        = "shield"
+	// DefaultMFAReenrollAge is how recent the second factor must be verified
+	// to replace a confirmed enrollment if none specified
+	DefaultMFAReenrollAge = 5 * time.Minute
+
+	// mfaScheme is the auth-scheme of the challenge of routes requiring recent MFA
+	mfaScheme = "MFA"
+)
+
+var (
+	_ MFAStore = (*MemoryMFAStore)(nil)
+
+	// ErrMFARequired is returned when the second factor has to be verified first
+	ErrMFARequired = errors.New("mfa required")
+	// ErrMFANotEnrolled is returned when verifying without enrollment
+	ErrMFANotEnrolled = errors.New("mfa not enrolled")
+	// ErrInvalidMFACode is returned when the code is wrong or has been used
+	ErrInvalidMFACode = errors.New("invalid mfa code")
+)
+
+// NewMemoryMFAStore returns an empty MemoryMFAStore
+func NewMemoryMFAStore() *MemoryMFAStore {
+	return &MemoryMFAStore{enrollments: make(map[string]*MFAEnrollment)}
+}
+
+func (s *MemoryMFAStore) Load(ctx context.Context, principal string) (*MFAEnrollment, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, ok := s.enrollments[principal]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *enrollment
+	return &copied, nil
+}
+
+func (s *MemoryMFAStore) Save(ctx context.Context, principal string, enrollment *MFAEnrollment) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *enrollment
+	s.enrollments[principal] = &copied
+
+	return nil
+}
+
+func (s *MemoryMFAStore) Accept(ctx context.Context, principal string, secret string, step int64) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, ok := s.enrollments[principal]
+	if !ok || step <= enrollment.LastStep {
+		return false, nil
+	}
+
+	switch secret {
+	case enrollment.PendingSecret:
+		enrollment.Secret = enrollment.PendingSecret
+		enrollment.PendingSecret = ""
+	case enrollment.Secret:
+	default:
+		return false, nil
+	}
+
+	enrollment.LastStep = step
+	return true, nil
+}
+
+func NewMFAMiddleware(subject security.Subject, store MFAStore, opts ...MFAOption) *MFAMiddleware {
+	m := &MFAMiddleware{subject: subject, store: store}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.totp == nil {
+		m.totp = NewTOTP()
+	}
+
+	if len(m.issuer) == 0 {
+		m.issuer = DefaultMFAIssuer
+	}
+
+	if len(m.enrollPath) == 0 {
+		m.enrollPath = DefaultMFAEnrollPath
+	}
+
+	if len(m.verifyPath) == 0 {
+		m.verifyPath = DefaultMFAVerifyPath
+	}
+
+	if len(m.parameter) == 0 {
+		m.parameter = DefaultMFACodeParameter
+	}
+
+	if m.reenrollAge <= 0 {
+		m.reenrollAge = DefaultMFAReenrollAge
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultMFAFailureHandler
+	}
+
+	return m
+}
+
+func (m *MFAMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			switch r.URL.Path {
+			case m.enrollPath:
+				m.enroll(w, r)
+				return
+			case m.verifyPath:
+				m.verify(w, r)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// enroll generates a new secret, which is pending
+// until a code of it has been verified
+func (m *MFAMiddleware) enroll(w http.ResponseWriter, r *http.Request) {
+	user, err := m.subject.UserDetails(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	enrollment, err := m.store.Load(r.Context(), user.Principal())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	// otherwise a stolen session could take over the second factor
+	if enrollment != nil && len(enrollment.Secret) > 0 &&
+		!pattern.RecentMFA(r.Context(), m.subject, m.reenrollAge) {
+		challengeMFA(w, m.reenrollAge)
+		m.failureHandler(w, r, ErrMFARequired)
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if enrollment == nil {
+		enrollment = &MFAEnrollment{}
+	}
+
+	enrollment.PendingSecret = secret
+	if err = m.store.Save(r.Context(), user.Principal(), enrollment); err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+		Data    any    `json:"data"`    // 绑定信息
+	}{
+		Code:    http.StatusOK,
+		Message: "请使用验证器扫码后输入验证码",
+		Data: struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		}{
+			Secret: secret,
+			URI:    m.totp.URI(m.issuer, user.Principal(), secret),
+		},
+	})
+}
+
+func (m *MFAMiddleware) verify(w http.ResponseWriter, r *http.Request) {
+	user, err := m.subject.UserDetails(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	params, err := parseParameters(w, r, m.parameter)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	// the client IP is only counted, see FormLoginMiddleware
+	principal := user.Principal()
+	if m.throttler != nil {
+		if err = m.throttler.Check(r.Context(), principal, ""); err != nil {
+			m.failureHandler(w, r, err)
+			return
+		}
+	}
+
+	enrollment, err := m.store.Load(r.Context(), principal)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if enrollment == nil || len(enrollment.Secret)+len(enrollment.PendingSecret) == 0 {
+		m.failureHandler(w, r, ErrMFANotEnrolled)
+		return
+	}
+
+	secret, step, ok, err := m.validate(enrollment, params[m.parameter])
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	// a code is accepted once, even if presented concurrently
+	if ok {
+		ok, err = m.store.Accept(r.Context(), principal, secret, step)
+		if err != nil {
+			m.failureHandler(w, r, err)
+			return
+		}
+	}
+
+	if !ok {
+		if m.throttler != nil {
+			if terr := m.throttler.Failed(r.Context(), principal, m.throttler.ClientIP(r)); terr != nil {
+				log.Printf("record failure failed: %s\n", terr.Error())
+			}
+		}
+		m.failureHandler(w, r, ErrInvalidMFACode)
+		return
+	}
+
+	if m.throttler != nil {
+		if err = m.throttler.Succeeded(r.Context(), principal); err != nil {
+			log.Printf("reset failures failed: %s\n", err.Error())
+		}
+	}
+
+	if err = pattern.RecordMFA(r.Context(), m.subject); err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusOK,
+		Message: "验证成功",
+	})
+}
+
+// validate tries the pending secret first, and returns the
+// secret the code matches along with its time step
+func (m *MFAMiddleware) validate(enrollment *MFAEnrollment, code string) (string, int64, bool, error) {
+	for _, secret := range []string{enrollment.PendingSecret, enrollment.Secret} {
+		if len(secret) == 0 {
+			continue
+		}
+
+		decoded, err := DecodeTOTPSecret(secret)
+		if err != nil {
+			return "", 0, false, err
+		}
+
+		if step, ok := m.totp.Validate(decoded, code, nowFunc()); ok {
+			return secret, step, true, nil
+		}
+	}
+
+	return "", 0, false, nil
+}
+
+// challengeMFA adds a challenge telling the client to verify
+// the second factor, which is distinct from the challenges
+// of Mechanism(s) so that the frontend can react to it
+func challengeMFA(w http.ResponseWriter, maxAge time.Duration) {
+	seconds := strconv.FormatInt(int64(maxAge/time.Second), 10)
+	w.Header().Add(wwwAuthenticateHeader, mfaScheme+` realm="shield", max_age=`+quoteString(seconds))
+}
+
+func defaultMFAFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	// never dump the body, which carries the code
+	log.Printf("mfa failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	code := http.StatusUnauthorized
+	if throttled, retryAfter, ok := evalThrottle(err); ok {
+		code = throttled
+		w.Header().Set(retryAfterHeader, retryAfter)
+	} else if errors.Is(err, ErrMFANotEnrolled) || errors.Is(err, ErrMalformedCredentials) {
+		code = http.StatusBadRequest
+	}
+
+	message := evalMessage(err)
+	if errors.Is(err, ErrMalformedCredentials) {
+		message = "验证码格式不正确"
+	}
+
+	writeJSON(w, code, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    int32(code),
+		Message: message,
+	})
+}
+
+func WithTOTP(totp *TOTP) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.totp = totp
+	}
+}
+
+// WithMFAIssuer specifies the issuer shown by authenticator apps
+func WithMFAIssuer(issuer string) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.issuer = issuer
+	}
+}
+
+func WithMFAEnrollPath(path string) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.enrollPath = path
+	}
+}
+
+func WithMFAVerifyPath(path string) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.verifyPath = path
+	}
+}
+
+func WithMFACodeParameter(name string) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.parameter = name
+	}
+}
+
+// WithMFAReenrollAge specifies how recent the second factor
+// must be verified to replace a confirmed enrollment
+func WithMFAReenrollAge(maxAge time.Duration) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.reenrollAge = maxAge
+	}
+}
+
+// WithMFAThrottler throttles wrong codes per principal and client IP
+func WithMFAThrottler(throttler *Throttler) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.throttler = throttler
+	}
+}
+
+func WithMFAFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) MFAOption {
+	return func(m *MFAMiddleware) {
+		m.failureHandler = handler
+	}
+}
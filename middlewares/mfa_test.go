@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// test vectors of RFC 6238 Appendix B
+	secret := []byte("12345678901234567890")
+	totp := NewTOTP(WithTOTPDigits(8))
+	for at, code := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, code, totp.Generate(secret, time.Unix(at, 0)))
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := totp.Validate(secret, totp.Generate(secret, now.Add(-30*time.Second)), now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now)-1, step)
+
+	_, ok = totp.Validate(secret, totp.Generate(secret, now.Add(-90*time.Second)), now)
+	assert.False(t, ok)
+
+	encoded, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	decoded, err := DecodeTOTPSecret(strings.ToLower(encoded))
+	assert.NoError(t, err)
+	assert.Len(t, decoded, totpSecretSize)
+	assert.True(t, strings.HasPrefix(NewTOTP().URI("shield", "archer", encoded), "otpauth://totp/shield:archer?"))
+}
+
+func TestMFA(t *testing.T) {
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	registry := pattern.NewRouteRegistry().
+		AntMatches("/payouts").RequiresRecentMFA(5 * time.Minute).
+		AnyRequests().PermitAll()
+
+	handler := NewMFAMiddleware(subject, NewMemoryMFAStore()).Handle(
+		NewAuthzMiddleware(subject, WithUnanimousMode(), WithRouteRegistry(registry)).Handle(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+
+	serve := func(ctx context.Context, method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", formContentType)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// not authenticated at all
+	w := serve(context.Background(), http.MethodGet, "/payouts", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get(wwwAuthenticateHeader))
+
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+
+	w = serve(ctx, http.MethodGet, "/payouts", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `MFA realm="shield", max_age="300"`, w.Header().Get(wwwAuthenticateHeader))
+	assert.Equal(t, http.StatusTeapot, serve(ctx, http.MethodGet, "/orders", "").Code)
+
+	assert.Equal(t, http.StatusBadRequest, serve(ctx, http.MethodPost, DefaultMFAVerifyPath, "code=123456").Code)
+
+	w = serve(ctx, http.MethodPost, DefaultMFAEnrollPath, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Data struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotEmpty(t, body.Data.URI)
+
+	secret, err := DecodeTOTPSecret(body.Data.Secret)
+	assert.NoError(t, err)
+	code := NewTOTP().Generate(secret, time.Now())
+	wrong := NewTOTP().Generate(secret, time.Now().Add(time.Hour))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(ctx, http.MethodPost, DefaultMFAVerifyPath, "code="+wrong).Code)
+	assert.Equal(t, http.StatusOK, serve(ctx, http.MethodPost, DefaultMFAVerifyPath, "code="+code).Code)
+	assert.Equal(t, http.StatusTeapot, serve(ctx, http.MethodGet, "/payouts", "").Code)
+
+	// codes are accepted only once
+	assert.Equal(t, http.StatusUnauthorized, serve(ctx, http.MethodPost, DefaultMFAVerifyPath, "code="+code).Code)
+
+	// re-enrollment requires recent MFA, which other sessions lack
+	assert.Equal(t, http.StatusOK, serve(ctx, http.MethodPost, DefaultMFAEnrollPath, "").Code)
+	other, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+	w = serve(other, http.MethodPost, DefaultMFAEnrollPath, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get(wwwAuthenticateHeader), "MFA "))
+}
+
+func TestMFAStoreAccept(t *testing.T) {
+	store := NewMemoryMFAStore()
+	ctx := context.Background()
+	assert.NoError(t, store.Save(ctx, "archer", &MFAEnrollment{Secret: "old", PendingSecret: "new"}))
+
+	// only one of concurrent verifications of the same step wins
+	accepted := make(chan bool, 8)
+	for i := 0; i < cap(accepted); i++ {
+		go func() {
+			ok, err := store.Accept(ctx, "archer", "new", 42)
+			assert.NoError(t, err)
+			accepted <- ok
+		}()
+	}
+
+	wins := 0
+	for i := 0; i < cap(accepted); i++ {
+		if <-accepted {
+			wins++
+		}
+	}
+	assert.Equal(t, 1, wins)
+
+	enrollment, err := store.Load(ctx, "archer")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", enrollment.Secret)
+	assert.Empty(t, enrollment.PendingSecret)
+	assert.Equal(t, int64(42), enrollment.LastStep)
+
+	// the replaced secret is no longer accepted
+	ok, err := store.Accept(ctx, "archer", "old", 43)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,610 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield/security"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// OIDCClient describes the client registered at an OpenID Provider
+	OIDCClient struct {
+		// Issuer is the issuer identifier of the provider, which
+		// is used to discover the provider's configuration
+		Issuer string
+		// ClientID is the client identifier issued by the provider
+		ClientID string
+		// ClientSecret is the client secret issued by the provider
+		ClientSecret string
+		// RedirectURL is the callback URL registered at the provider,
+		// whose path is served by OIDCLoginMiddleware
+		RedirectURL string
+		// Scopes requested, openid is always included
+		Scopes []string
+	}
+
+	// OIDCProviderMetadata is the subset of the provider
+	// configuration defined by OpenID Connect Discovery
+	OIDCProviderMetadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint,omitempty"`
+	}
+
+	// AuthorizationRequest holds the secrets generated for an
+	// authorization request until the provider calls back
+	AuthorizationRequest struct {
+		// Nonce is bound to the ID token
+		Nonce string
+		// CodeVerifier is the PKCE code verifier
+		CodeVerifier string
+		// ExpiresAt is the time the request expires
+		ExpiresAt time.Time
+	}
+
+	// AuthorizationRequestStore is responsible for persisting
+	// AuthorizationRequest(s) keyed by state
+	AuthorizationRequestStore interface {
+		// Save saves the request with the specified state
+		Save(context.Context, string, *AuthorizationRequest) error
+		// Consume removes and returns the request with
+		// the specified state, or nil if not found
+		Consume(context.Context, string) (*AuthorizationRequest, error)
+	}
+
+	// MemoryAuthorizationRequestStore is an AuthorizationRequestStore backed by a map,
+	// it holds at most DefaultAuthorizationRequestLimit requests, expired requests
+	// are swept at most once per DefaultSweepInterval
+	MemoryAuthorizationRequestStore struct {
+		mu       sync.Mutex
+		requests map[string]*AuthorizationRequest
+		sweptAt  time.Time
+	}
+
+	OIDCOption func(*OIDCLoginMiddleware)
+
+	// OIDCLoginMiddleware performs the OpenID Connect authorization code
+	// flow with PKCE, it redirects the browser to the provider, validates
+	// the ID token on callback, and establishes a session via Subject.Login
+	OIDCLoginMiddleware struct {
+		subject        security.Subject
+		client         *OIDCClient
+		httpClient     *http.Client
+		store          AuthorizationRequestStore
+		path           string
+		callbackPath   string
+		principalClaim string
+		loginOpts      []security.LoginOption
+		fingerprinter  Fingerprinter
+		events         *EventPublisher
+		successHandler func(http.ResponseWriter, *http.Request)
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+
+		mu        sync.Mutex
+		metadata  *OIDCProviderMetadata
+		verifier  *jwt.Verifier
+		discovery *oidcDiscovery
+	}
+
+	// oidcDiscovery is a discovery in flight
+	oidcDiscovery struct {
+		done chan struct{}
+		err  error
+	}
+)
+
+const (
+	DefaultOIDCLoginPath = "/login/oidc"
+
+	// DefaultAuthorizationRequestLimit is the maximum number
+	// of requests held by MemoryAuthorizationRequestStore
+	DefaultAuthorizationRequestLimit = 10000
+
+	// OIDCSource is the source of PreAuthenticatedToken
+	// logged in by OIDCLoginMiddleware
+	OIDCSource = "oidc"
+
+	oidcStateCookie         = "oidc_state"
+	oidcDiscoveryPath       = "/.well-known/openid-configuration"
+	authorizationRequestTTL = 10 * time.Minute
+	maxOIDCBody             = 1 << 20
+)
+
+var (
+	_ AuthorizationRequestStore = (*MemoryAuthorizationRequestStore)(nil)
+
+	// ErrStateMismatch is returned when the callback state does not match
+	// the one issued to the browser, or the authorization request expires
+	ErrStateMismatch = errors.New("oidc state mismatch")
+	// ErrNonceMismatch is returned when the ID token is not bound to the authorization request
+	ErrNonceMismatch = errors.New("oidc nonce mismatch")
+	// ErrAuthorizationDenied is returned when the provider responds with an error
+	ErrAuthorizationDenied = errors.New("oidc authorization denied")
+	// ErrTooManyAuthorizationRequests is returned when too many authorization requests are pending
+	ErrTooManyAuthorizationRequests = errors.New("too many oidc authorization requests")
+)
+
+// NewMemoryAuthorizationRequestStore returns an empty MemoryAuthorizationRequestStore
+func NewMemoryAuthorizationRequestStore() *MemoryAuthorizationRequestStore {
+	return &MemoryAuthorizationRequestStore{requests: make(map[string]*AuthorizationRequest)}
+}
+
+func (s *MemoryAuthorizationRequestStore) Save(ctx context.Context, state string, request *AuthorizationRequest) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := nowFunc()
+	if now.Sub(s.sweptAt) >= DefaultSweepInterval {
+		s.sweptAt = now
+		for key, req := range s.requests {
+			if !now.Before(req.ExpiresAt) {
+				delete(s.requests, key)
+			}
+		}
+	}
+
+	// unauthenticated requests must not grow the store without bound
+	if len(s.requests) >= DefaultAuthorizationRequestLimit {
+		return ErrTooManyAuthorizationRequests
+	}
+
+	s.requests[state] = request
+
+	return nil
+}
+
+func (s *MemoryAuthorizationRequestStore) Consume(ctx context.Context, state string) (*AuthorizationRequest, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request := s.requests[state]
+	delete(s.requests, state)
+
+	return request, nil
+}
+
+// DiscoverOIDCProvider fetches the configuration of the specified issuer
+func DiscoverOIDCProvider(ctx context.Context, client *http.Client, issuer string) (*OIDCProviderMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+oidcDiscoveryPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new discovery request: %w", err)
+	}
+
+	var metadata OIDCProviderMetadata
+	if err = doJSON(client, req, &metadata); err != nil {
+		return nil, fmt.Errorf("discover oidc provider: %w", err)
+	}
+
+	// prevents a compromised discovery document from impersonating another issuer
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discover oidc provider: issuer mismatch %q", metadata.Issuer)
+	}
+
+	if len(metadata.AuthorizationEndpoint) == 0 || len(metadata.TokenEndpoint) == 0 || len(metadata.JWKSURI) == 0 {
+		return nil, errors.New("discover oidc provider: endpoints missing")
+	}
+
+	return &metadata, nil
+}
+
+func NewOIDCLoginMiddleware(subject security.Subject, client *OIDCClient, opts ...OIDCOption) *OIDCLoginMiddleware {
+	m := &OIDCLoginMiddleware{subject: subject, client: client}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.httpClient == nil {
+		m.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if m.store == nil {
+		m.store = NewMemoryAuthorizationRequestStore()
+	}
+
+	if len(m.path) == 0 {
+		m.path = DefaultOIDCLoginPath
+	}
+
+	if u, err := url.Parse(client.RedirectURL); err == nil {
+		m.callbackPath = u.Path
+	}
+
+	if len(m.principalClaim) == 0 {
+		m.principalClaim = jwt.SubjectClaim
+	}
+
+	if len(m.loginOpts) == 0 {
+		m.loginOpts = []security.LoginOption{security.WithRenewToken()}
+	}
+
+	if m.successHandler == nil {
+		m.successHandler = defaultLoginSuccessHandler(subject)
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultOIDCFailureHandler
+	}
+
+	return m
+}
+
+func (m *OIDCLoginMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case m.path:
+			m.redirect(w, r)
+		case m.callbackPath:
+			m.callback(w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func (m *OIDCLoginMiddleware) redirect(w http.ResponseWriter, r *http.Request) {
+	metadata, _, err := m.discover(r.Context())
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	request := &AuthorizationRequest{ExpiresAt: nowFunc().Add(authorizationRequestTTL)}
+	if request.Nonce, err = randomString(32); err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if request.CodeVerifier, err = randomString(32); err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	if err = m.store.Save(r.Context(), state, request); err != nil {
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(request.CodeVerifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", m.client.ClientID)
+	query.Set("redirect_uri", m.client.RedirectURL)
+	query.Set("scope", m.scope())
+	query.Set("state", state)
+	query.Set("nonce", request.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	// binds the state to this browser, which prevents login CSRF
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     m.callbackPath,
+		MaxAge:   int(authorizationRequestTTL / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	target := metadata.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (m *OIDCLoginMiddleware) callback(w http.ResponseWriter, r *http.Request) {
+	// the state is single use in any case
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     m.callbackPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	query := r.URL.Query()
+	if code := query.Get("error"); len(code) > 0 {
+		m.fail(w, r, fmt.Errorf("%w: %s %s", ErrAuthorizationDenied, code, query.Get("error_description")))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || len(state) == 0 || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		m.fail(w, r, ErrStateMismatch)
+		return
+	}
+
+	request, err := m.store.Consume(r.Context(), state)
+	if err != nil {
+		m.fail(w, r, err)
+		return
+	}
+
+	if request == nil || !nowFunc().Before(request.ExpiresAt) {
+		m.fail(w, r, ErrStateMismatch)
+		return
+	}
+
+	claims, err := m.exchange(r.Context(), query.Get("code"), request)
+	if err != nil {
+		m.fail(w, r, err)
+		return
+	}
+
+	principal := claims.String(m.principalClaim)
+	if len(principal) == 0 {
+		m.fail(w, r, fmt.Errorf("oidc claim %s not found", m.principalClaim))
+		return
+	}
+
+	ctx, err := m.subject.Login(jwt.NewContext(r.Context(), claims), NewPreAuthenticatedToken(principal, OIDCSource), m.loginOpts...)
+	if err == nil {
+		err = BindSession(ctx, r, m.subject, m.fingerprinter)
+	}
+	if err != nil {
+		m.events.Publish(r.Context(), NewAuthEvent(r, AuthenticationFailed, principal, OIDCMechanismName, err))
+		m.failureHandler(w, r, err)
+		return
+	}
+
+	m.events.Publish(ctx, NewAuthEvent(r, AuthenticationSucceeded, principal, OIDCMechanismName, nil))
+	m.successHandler(w, r.WithContext(ctx))
+}
+
+func (m *OIDCLoginMiddleware) fail(w http.ResponseWriter, r *http.Request, err error) {
+	m.events.Publish(r.Context(), NewAuthEvent(r, AuthenticationFailed, "", OIDCMechanismName, err))
+	m.failureHandler(w, r, err)
+}
+
+// exchange redeems the authorization code, and returns the validated ID token claims
+func (m *OIDCLoginMiddleware) exchange(ctx context.Context, code string, request *AuthorizationRequest) (jwt.Claims, error) {
+	if len(code) == 0 {
+		return nil, fmt.Errorf("%w: code missing", ErrAuthorizationDenied)
+	}
+
+	metadata, verifier, err := m.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", m.client.RedirectURL)
+	form.Set("code_verifier", request.CodeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("new token request: %w", err)
+	}
+	req.SetBasicAuth(url.QueryEscape(m.client.ClientID), url.QueryEscape(m.client.ClientSecret))
+	req.Header.Set("Content-Type", formContentType)
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = doJSON(m.httpClient, req, &tokens); err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	if len(tokens.IDToken) == 0 {
+		return nil, errors.New("exchange code: id_token missing")
+	}
+
+	claims, err := verifier.Verify(ctx, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// the verifier only checks `exp` if present, which is required here
+	if _, ok := claims.ExpiresAt(); !ok {
+		return nil, jwt.ErrMalformed
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.String(jwt.NonceClaim)), []byte(request.Nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// discover fetches the provider configuration on first use, concurrent
+// requests share one fetch, failures are not cached so that the next
+// request retries
+func (m *OIDCLoginMiddleware) discover(ctx context.Context) (*OIDCProviderMetadata, *jwt.Verifier, error) {
+	m.mu.Lock()
+	if m.metadata != nil {
+		defer m.mu.Unlock()
+		return m.metadata, m.verifier, nil
+	}
+
+	call := m.discovery
+	if call == nil {
+		call = &oidcDiscovery{done: make(chan struct{})}
+		m.discovery = call
+		go m.fetch(call)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		return nil, nil, call.err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.metadata, m.verifier, nil
+}
+
+// fetch runs in background, so that it is neither canceled
+// by the request that triggered it, nor holds the lock
+func (m *OIDCLoginMiddleware) fetch(call *oidcDiscovery) {
+	metadata, err := DiscoverOIDCProvider(context.Background(), m.httpClient, m.client.Issuer)
+
+	m.mu.Lock()
+	if err == nil {
+		m.metadata = metadata
+		m.verifier = jwt.NewVerifier(
+			jwt.NewJWKS(metadata.JWKSURI, jwt.WithJWKSClient(m.httpClient)),
+			jwt.WithIssuer(metadata.Issuer),
+			jwt.WithAudience(m.client.ClientID),
+			jwt.WithRequiredExpiry(),
+			jwt.WithNowFunc(nowFunc),
+		)
+	}
+	call.err = err
+	m.discovery = nil
+	m.mu.Unlock()
+
+	close(call.done)
+}
+
+func (m *OIDCLoginMiddleware) scope() string {
+	scopes := []string{"openid"}
+	for _, scope := range m.client.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return strings.Join(scopes, " ")
+}
+
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", jsonContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCBody)).Decode(v)
+}
+
+func defaultOIDCFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("oidc login failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	writeJSON(w, http.StatusUnauthorized, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusUnauthorized,
+		Message: "单点登录失败，请重新登录",
+	})
+}
+
+func WithOIDCHTTPClient(client *http.Client) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.httpClient = client
+	}
+}
+
+// WithAuthorizationRequestStore replaces the in-memory store, which
+// must be shared across instances when running more than one
+func WithAuthorizationRequestStore(store AuthorizationRequestStore) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.store = store
+	}
+}
+
+// WithOIDCLoginPath specifies the path that starts the flow
+func WithOIDCLoginPath(path string) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.path = path
+	}
+}
+
+// WithOIDCPrincipalClaim specifies the ID token claim used as principal, `sub` if none specified
+func WithOIDCPrincipalClaim(name string) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.principalClaim = name
+	}
+}
+
+func WithOIDCLoginOptions(opts ...security.LoginOption) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.loginOpts = append(m.loginOpts, opts...)
+	}
+}
+
+// WithOIDCFingerprint binds the sessions to the client that logged in, see BindSession
+func WithOIDCFingerprint(fingerprinter Fingerprinter) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.fingerprinter = fingerprinter
+	}
+}
+
+// WithOIDCEvents publishes login outcomes to the specified publisher
+func WithOIDCEvents(events *EventPublisher) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.events = events
+	}
+}
+
+// WithOIDCSuccessHandler specifies the handler called once the session is established,
+// the ID token claims are available through jwt.FromContext
+func WithOIDCSuccessHandler(handler func(http.ResponseWriter, *http.Request)) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.successHandler = handler
+	}
+}
+
+func WithOIDCFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) OIDCOption {
+	return func(m *OIDCLoginMiddleware) {
+		m.failureHandler = handler
+	}
+}
@@ -0,0 +1,153 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newFakeProvider returns an OpenID Provider that issues ID tokens for alice
+func newFakeProvider(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var (
+		server    *httptest.Server
+		challenge string
+		nonce     string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &OIDCProviderMetadata{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		challenge = r.URL.Query().Get("code_challenge")
+		nonce = r.URL.Query().Get("nonce")
+		http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?code=xyz&state="+r.URL.Query().Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []*jwt.JWK{{
+			KeyType: "RSA",
+			KeyID:   "k1",
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		digest := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "xyz" || base64.RawURLEncoding.EncodeToString(digest[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		header, _ := json.Marshal(jwt.Header{Algorithm: jwt.RS256, KeyID: "k1"})
+		payload, _ := json.Marshal(jwt.Claims{
+			"iss":   server.URL,
+			"sub":   "alice",
+			"aud":   "web",
+			"nonce": nonce,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+		input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		hashed := sha256.Sum256([]byte(input))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+		assert.NoError(t, err)
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": "opaque",
+			"token_type":   "Bearer",
+			"id_token":     input + "." + base64.RawURLEncoding.EncodeToString(signature),
+		})
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+
+	subject := newShieldSubject(passwordRealm{"alice": ""})
+	handler := NewOIDCLoginMiddleware(subject, &OIDCClient{
+		Issuer:       provider.URL,
+		ClientID:     "web",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/login/oidc/callback",
+		Scopes:       []string{"profile"},
+	}).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "openid profile", location.Query().Get("scope"))
+	assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+
+	// let the provider call back
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}).Get(location.String())
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	callback := resp.Header.Get("Location")
+
+	// a forged callback without the state cookie
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, callback, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "登录成功")
+
+	// the state is single use
+	r = httptest.NewRequest(http.MethodGet, callback, nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMemoryAuthorizationRequestStoreLimit(t *testing.T) {
+	store := NewMemoryAuthorizationRequestStore()
+	request := &AuthorizationRequest{ExpiresAt: time.Now().Add(time.Hour)}
+	for i := 0; i < DefaultAuthorizationRequestLimit; i++ {
+		assert.NoError(t, store.Save(context.TODO(), strconv.Itoa(i), request))
+	}
+
+	assert.ErrorIs(t, store.Save(context.TODO(), "overflow", request), ErrTooManyAuthorizationRequests)
+
+	consumed, err := store.Consume(context.TODO(), "0")
+	assert.NoError(t, err)
+	assert.Equal(t, request, consumed)
+	assert.NoError(t, store.Save(context.TODO(), "overflow", request))
+}
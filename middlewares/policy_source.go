@@ -0,0 +1,214 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/shrinex/shield-web/pattern"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// PolicySource provides the RouteRegistry evaluated by AuthzMiddleware,
+	// which reads it once per request, a RouteRegistry must not be
+	// modified once it has been handed to a PolicySource
+	PolicySource interface {
+		Registry() *pattern.RouteRegistry
+	}
+
+	// AtomicPolicySource is a PolicySource whose RouteRegistry
+	// can be swapped while requests are being served
+	AtomicPolicySource struct {
+		registry atomic.Value
+	}
+
+	PolicyWatcherOption func(*PolicyFileWatcher)
+
+	// PolicyFileWatcher is a PolicySource that reloads a policy file once it
+	// changes, see pattern.LoadPolicyFile, an invalid or empty policy is
+	// reported and the last valid one is kept, writers must replace the
+	// file atomically, i.e. write a temporary file then rename it, since
+	// a file being written may be read half-way as a valid policy
+	PolicyFileWatcher struct {
+		*AtomicPolicySource
+		name     string
+		interval time.Duration
+		listener func(error)
+		mu       sync.Mutex
+		digest   [sha256.Size]byte
+		stop     chan struct{}
+		done     chan struct{}
+	}
+)
+
+const DefaultPolicyPollInterval = 2 * time.Second
+
+var (
+	_ PolicySource = (*AtomicPolicySource)(nil)
+	_ PolicySource = (*PolicyFileWatcher)(nil)
+)
+
+// NewAtomicPolicySource returns an AtomicPolicySource of the specified registry
+func NewAtomicPolicySource(registry *pattern.RouteRegistry) *AtomicPolicySource {
+	s := &AtomicPolicySource{}
+	s.Swap(registry)
+	return s
+}
+
+func (s *AtomicPolicySource) Registry() *pattern.RouteRegistry {
+	return s.registry.Load().(*pattern.RouteRegistry)
+}
+
+// Swap replaces the registry, requests being evaluated keep the old one
+func (s *AtomicPolicySource) Swap(registry *pattern.RouteRegistry) {
+	if registry == nil {
+		registry = pattern.NewRouteRegistry()
+	}
+
+	s.registry.Store(registry)
+}
+
+// NewPolicyFileWatcher loads the policy file, which must be valid,
+// call Start to watch it and Close to stop watching
+func NewPolicyFileWatcher(name string, opts ...PolicyWatcherOption) (*PolicyFileWatcher, error) {
+	w := &PolicyFileWatcher{name: name}
+
+	for _, f := range opts {
+		f(w)
+	}
+
+	if w.interval <= 0 {
+		w.interval = DefaultPolicyPollInterval
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := loadPolicy(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	w.AtomicPolicySource = NewAtomicPolicySource(registry)
+	w.digest = sha256.Sum256(data)
+
+	return w, nil
+}
+
+// Start polls the policy file in background, the contents are
+// compared rather than the modification time, which is too
+// coarse on some file systems to notice quick edits
+func (w *PolicyFileWatcher) Start() *PolicyFileWatcher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		return w
+	}
+
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.poll(w.stop, w.done)
+
+	return w
+}
+
+// Close stops watching and waits for the polling goroutine to exit
+func (w *PolicyFileWatcher) Close() {
+	w.mu.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (w *PolicyFileWatcher) poll(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := w.Reload(); err != nil {
+				log.Printf("reload policy failed, keep the last valid one: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// Reload loads the policy file if it changed since last loaded, the
+// registry is swapped only if the policy is valid, reloaded is
+// false if the file is unchanged or invalid
+func (w *PolicyFileWatcher) Reload() (reloaded bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.name)
+	if err != nil {
+		w.notify(err)
+		return false, err
+	}
+
+	digest := sha256.Sum256(data)
+	if digest == w.digest {
+		return false, nil
+	}
+
+	registry, err := loadPolicy(w.name, data)
+	if err != nil {
+		// so that the same invalid policy is reported once
+		w.digest = digest
+		w.notify(err)
+		return false, err
+	}
+
+	w.digest = digest
+	w.Swap(registry)
+	w.notify(nil)
+
+	return true, nil
+}
+
+func (w *PolicyFileWatcher) notify(err error) {
+	if w.listener != nil {
+		w.listener(err)
+	}
+}
+
+func loadPolicy(name string, data []byte) (*pattern.RouteRegistry, error) {
+	registry, err := pattern.LoadPolicy(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return registry, nil
+}
+
+// WithPolicyPollInterval specifies how often the policy file is checked
+func WithPolicyPollInterval(interval time.Duration) PolicyWatcherOption {
+	return func(w *PolicyFileWatcher) {
+		w.interval = interval
+	}
+}
+
+// WithPolicyReloadListener is called after every reload attempt
+// of a changed policy file, with nil if it succeeded
+func WithPolicyReloadListener(listener func(error)) PolicyWatcherOption {
+	return func(w *PolicyFileWatcher) {
+		w.listener = listener
+	}
+}
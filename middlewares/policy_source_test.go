@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyFileWatcher(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "policy.yaml")
+	write := func(policy string) {
+		assert.NoError(t, os.WriteFile(name, []byte(policy), 0o600))
+	}
+
+	write("rules:\n  - patterns: [/admin/**]\n    kind: denyAll\n")
+
+	var (
+		mu      sync.Mutex
+		reloads []error
+	)
+	watcher, err := NewPolicyFileWatcher(name, WithPolicyPollInterval(10*time.Millisecond), WithPolicyReloadListener(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reloads = append(reloads, err)
+	}))
+	assert.NoError(t, err)
+	defer watcher.Close()
+
+	handler := NewAuthzMiddleware(newFakeSubject(), WithPolicySource(watcher)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve("/admin/users"))
+	assert.Equal(t, http.StatusTeapot, serve("/orders"))
+
+	// unchanged
+	reloaded, err := watcher.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// invalid policies are reported, and the last valid one is kept
+	write("rules:\n  - patterns: [/admin/**]\n    kind: deny\n")
+	reloaded, err = watcher.Reload()
+	assert.ErrorContains(t, err, `unknown kind "deny"`)
+	assert.False(t, reloaded)
+	assert.Equal(t, http.StatusForbidden, serve("/admin/users"))
+
+	// a file truncated by the writer is not a policy permitting everything
+	write("")
+	reloaded, err = watcher.Reload()
+	assert.ErrorIs(t, err, pattern.ErrEmptyPolicy)
+	assert.False(t, reloaded)
+	assert.Equal(t, http.StatusForbidden, serve("/admin/users"))
+
+	write("rules: []\n")
+	_, err = watcher.Reload()
+	assert.ErrorIs(t, err, pattern.ErrEmptyPolicy)
+	assert.Equal(t, http.StatusForbidden, serve("/admin/users"))
+
+	write("rules:\n  - patterns: [/orders/**]\n    kind: denyAll\n")
+	reloaded, err = watcher.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, http.StatusTeapot, serve("/admin/users"))
+	assert.Equal(t, http.StatusForbidden, serve("/orders"))
+
+	// picked up in background
+	watcher.Start()
+	write("rules:\n  - patterns: [/**]\n    kind: permitAll\n")
+	assert.Eventually(t, func() bool {
+		return serve("/orders") == http.StatusTeapot
+	}, time.Second, 10*time.Millisecond)
+
+	watcher.Close()
+	mu.Lock()
+	assert.Len(t, reloads, 5)
+	assert.Error(t, reloads[0])
+	assert.Error(t, reloads[1])
+	assert.Error(t, reloads[2])
+	assert.NoError(t, reloads[3])
+	assert.NoError(t, reloads[4])
+	mu.Unlock()
+
+	_, err = NewPolicyFileWatcher(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield-web/pattern"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+)
+
+type (
+	// Principal is an identity authenticated without a shield session,
+	// e.g. by an API key, its roles and authorities are visible to the
+	// predicates of AuthzMiddleware through NewPrincipalSubject
+	Principal struct {
+		// Name identifies the principal
+		Name string
+		// Roles granted to the principal
+		Roles []authz.Role
+		// Authorities granted to the principal
+		Authorities []authz.Authority
+		// Attributes holds mechanism specific details
+		Attributes map[string]any
+		// Anonymous is true if the principal stands for an
+		// unauthenticated request, see AnonymousMiddleware
+		Anonymous bool
+	}
+
+	principalCtxKey struct{}
+
+	// principalSubject consults the Principal stored in
+	// context first, and falls back to the wrapped Subject
+	principalSubject struct {
+		security.Subject
+	}
+)
+
+var (
+	_ authc.UserDetails       = (*Principal)(nil)
+	_ pattern.AttributeSource = (*Principal)(nil)
+	_ security.Subject        = (*principalSubject)(nil)
+)
+
+// ContextWithPrincipal returns a copy of ctx which carries the specified Principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// PrincipalFromContext returns the Principal stored in ctx, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// NewPrincipalSubject wraps the specified Subject so that authentication and
+// authorization queries are answered by the Principal stored in context, if any
+func NewPrincipalSubject(subject security.Subject) security.Subject {
+	if _, ok := subject.(*principalSubject); ok {
+		return subject
+	}
+
+	return &principalSubject{Subject: subject}
+}
+
+func (p *Principal) Principal() string {
+	return p.Name
+}
+
+func (p *Principal) Attribute(name string) (any, bool) {
+	value, ok := p.Attributes[name]
+	return value, ok
+}
+
+func (p *Principal) hasRole(role authz.Role) bool {
+	for _, v := range p.Roles {
+		if v.Implies(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Principal) hasAuthority(authority authz.Authority) bool {
+	for _, v := range p.Authorities {
+		if v.Implies(authority) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *principalSubject) Authenticated(ctx context.Context) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return !p.Anonymous
+	}
+
+	return s.Subject.Authenticated(ctx)
+}
+
+func (s *principalSubject) UserDetails(ctx context.Context) (authc.UserDetails, error) {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p, nil
+	}
+
+	return s.Subject.UserDetails(ctx)
+}
+
+func (s *principalSubject) HasRole(ctx context.Context, role authz.Role) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p.hasRole(role)
+	}
+
+	return s.Subject.HasRole(ctx, role)
+}
+
+func (s *principalSubject) HasAnyRole(ctx context.Context, roles ...authz.Role) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		for _, role := range roles {
+			if p.hasRole(role) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return s.Subject.HasAnyRole(ctx, roles...)
+}
+
+func (s *principalSubject) HasAllRole(ctx context.Context, roles ...authz.Role) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		for _, role := range roles {
+			if !p.hasRole(role) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return s.Subject.HasAllRole(ctx, roles...)
+}
+
+func (s *principalSubject) HasAuthority(ctx context.Context, authority authz.Authority) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p.hasAuthority(authority)
+	}
+
+	return s.Subject.HasAuthority(ctx, authority)
+}
+
+func (s *principalSubject) HasAnyAuthority(ctx context.Context, authorities ...authz.Authority) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		for _, authority := range authorities {
+			if p.hasAuthority(authority) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return s.Subject.HasAnyAuthority(ctx, authorities...)
+}
+
+func (s *principalSubject) HasAllAuthority(ctx context.Context, authorities ...authz.Authority) bool {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		for _, authority := range authorities {
+			if !p.hasAuthority(authority) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return s.Subject.HasAllAuthority(ctx, authorities...)
+}
@@ -0,0 +1,232 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type (
+	// GroupMapper maps the forwarded groups to roles and authorities
+	GroupMapper func([]string) ([]authz.Role, []authz.Authority)
+
+	ProxyOption func(*proxyMechanism)
+
+	proxyMechanism struct {
+		trusted      []*net.IPNet
+		userHeader   string
+		groupsHeader string
+		mapper       GroupMapper
+	}
+)
+
+const (
+	// ProxyMechanismName is the name of the trusted proxy Mechanism
+	ProxyMechanismName = "proxy"
+
+	DefaultForwardedUserHeader   = "X-Forwarded-User"
+	DefaultForwardedGroupsHeader = "X-Forwarded-Groups"
+
+	// GroupsAttribute is the Principal attribute
+	// that holds the forwarded groups
+	GroupsAttribute = "groups"
+)
+
+var (
+	_ Mechanism = (*proxyMechanism)(nil)
+	_ Guard     = (*proxyMechanism)(nil)
+
+	// ErrUntrustedProxy is returned when identity headers come from a
+	// client that is not a trusted proxy, which may be a spoofing attempt
+	ErrUntrustedProxy = fmt.Errorf("identity headers from untrusted source: %w", authc.ErrInvalidToken)
+)
+
+// ParseTrustedProxies parses CIDRs like 10.0.0.0/8, a bare
+// IP is treated as a network of that single address
+func ParseTrustedProxies(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy CIDR %q: %w", cidr, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// NewProxyMiddleware returns an AuthcMiddleware that authenticates requests
+// with the identity forwarded by the trusted proxies, use NewAuthcMiddleware
+// along with NewProxyMechanism to specify ProxyOption(s) as well
+func NewProxyMiddleware(subject security.Subject, trusted []*net.IPNet, opts ...AuthcOption) *AuthcMiddleware {
+	return NewAuthcMiddleware(subject, append(opts, WithMechanism(NewProxyMechanism(trusted)))...)
+}
+
+// NewProxyMechanism returns a Mechanism that trusts the user and groups
+// forwarded by an authenticating reverse proxy, the headers are honored
+// only if http.Request.RemoteAddr belongs to the trusted networks, and
+// rejected otherwise, on every request, even the ones skipped by the
+// patterns, and wherever it is registered since it is a Guard,
+// the forwarded user is stored in context as Principal whose roles
+// are the forwarded groups if no GroupMapper specified
+func NewProxyMechanism(trusted []*net.IPNet, opts ...ProxyOption) Mechanism {
+	m := &proxyMechanism{trusted: trusted}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.userHeader) == 0 {
+		m.userHeader = DefaultForwardedUserHeader
+	}
+
+	if len(m.groupsHeader) == 0 {
+		m.groupsHeader = DefaultForwardedGroupsHeader
+	}
+
+	if m.mapper == nil {
+		m.mapper = GroupsAsRoles
+	}
+
+	return m
+}
+
+func (m *proxyMechanism) Name() string {
+	return ProxyMechanismName
+}
+
+// Guard rejects identity headers from untrusted sources, so that they never
+// reach handlers even if another mechanism authenticates the request
+func (m *proxyMechanism) Guard(r *http.Request) error {
+	_, hasUser := r.Header[http.CanonicalHeaderKey(m.userHeader)]
+	_, hasGroups := r.Header[http.CanonicalHeaderKey(m.groupsHeader)]
+	if !hasUser && !hasGroups {
+		return nil
+	}
+
+	// X-Forwarded-For is not used, since it can be forged as well
+	if !m.trusts(RemoteIP(r)) {
+		return ErrUntrustedProxy
+	}
+
+	return nil
+}
+
+func (m *proxyMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	_, hasUser := r.Header[http.CanonicalHeaderKey(m.userHeader)]
+	_, hasGroups := r.Header[http.CanonicalHeaderKey(m.groupsHeader)]
+	if !hasUser && !hasGroups {
+		return r.Context(), ErrCredentialsNotFound
+	}
+
+	if err := m.Guard(r); err != nil {
+		return r.Context(), err
+	}
+
+	name := strings.TrimSpace(r.Header.Get(m.userHeader))
+	if len(name) == 0 {
+		return r.Context(), ErrCredentialsNotFound
+	}
+
+	groups := splitGroups(r.Header.Values(m.groupsHeader))
+	principal := &Principal{
+		Name:       name,
+		Attributes: map[string]any{GroupsAttribute: groups},
+	}
+
+	principal.Roles, principal.Authorities = m.mapper(groups)
+
+	return ContextWithPrincipal(r.Context(), principal), nil
+}
+
+func (m *proxyMechanism) trusts(remote string) bool {
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range m.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitGroups splits comma separated header values
+func splitGroups(values []string) []string {
+	var groups []string
+	for _, value := range values {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); len(group) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	return groups
+}
+
+// GroupsAsRoles maps every group to a role of the same name
+func GroupsAsRoles(groups []string) ([]authz.Role, []authz.Authority) {
+	roles := make([]authz.Role, 0, len(groups))
+	for _, group := range groups {
+		roles = append(roles, authz.NewRole(group))
+	}
+
+	return roles, nil
+}
+
+// GroupRoleMapping returns a GroupMapper that maps groups to the
+// specified roles, groups not in mapping are ignored
+func GroupRoleMapping(mapping map[string][]authz.Role) GroupMapper {
+	return func(groups []string) ([]authz.Role, []authz.Authority) {
+		var roles []authz.Role
+		for _, group := range groups {
+			roles = append(roles, mapping[group]...)
+		}
+
+		return roles, nil
+	}
+}
+
+func WithForwardedUserHeader(name string) ProxyOption {
+	return func(m *proxyMechanism) {
+		m.userHeader = name
+	}
+}
+
+func WithForwardedGroupsHeader(name string) ProxyOption {
+	return func(m *proxyMechanism) {
+		m.groupsHeader = name
+	}
+}
+
+func WithGroupMapper(mapper GroupMapper) ProxyOption {
+	return func(m *proxyMechanism) {
+		m.mapper = mapper
+	}
+}
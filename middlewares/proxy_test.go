@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyMechanism(t *testing.T) {
+	_, err := ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+
+	trusted, err := ParseTrustedProxies("10.0.0.0/8", "192.168.1.1", "::1")
+	assert.NoError(t, err)
+	mechanism := NewProxyMechanism(trusted, WithGroupMapper(GroupRoleMapping(map[string][]authz.Role{
+		"finance": {authz.NewRole("accountant")},
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+	_, err = mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrCredentialsNotFound)
+
+	// spoofed by a client talking to the server directly
+	r.Header.Set(DefaultForwardedUserHeader, "alice")
+	_, err = mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrUntrustedProxy)
+
+	r.Header.Del(DefaultForwardedUserHeader)
+	r.Header.Set(DefaultForwardedGroupsHeader, "admin")
+	_, err = mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrUntrustedProxy)
+
+	r.RemoteAddr = "192.168.1.1:1234"
+	_, err = mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrCredentialsNotFound)
+
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set(DefaultForwardedUserHeader, "alice")
+	r.Header.Set(DefaultForwardedGroupsHeader, "finance, staff")
+	r.Header.Add(DefaultForwardedGroupsHeader, "ops")
+	ctx, err := mechanism.Authenticate(r, nil)
+	assert.NoError(t, err)
+
+	principal, ok := PrincipalFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", principal.Name)
+	assert.Equal(t, []string{"finance", "staff", "ops"}, principal.Attributes[GroupsAttribute])
+	assert.Len(t, principal.Roles, 1)
+	assert.Equal(t, "accountant", principal.Roles[0].Desc())
+
+	r.RemoteAddr = "[::1]:1234"
+	ctx, err = NewProxyMechanism(trusted).Authenticate(r, nil)
+	assert.NoError(t, err)
+	principal, _ = PrincipalFromContext(ctx)
+	assert.Len(t, principal.Roles, 3)
+}
+
+func TestProxyGuard(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+
+	subject := newFakeSubject()
+	subject.credentials["token"] = "token"
+	var principal *Principal
+	handler := NewAuthcMiddleware(subject, WithMechanisms(
+		NewBearerMechanism(NewBearerTokenResolver()),
+		NewProxyMechanism(trusted),
+	)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+	r.Header.Set(authorizationHeader, "Bearer token")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// rejected even though the bearer mechanism comes first
+	r.Header.Set(DefaultForwardedUserHeader, "admin")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, principal)
+}
+
+func TestProxyGuardSkippedPaths(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+
+	reached := false
+	handler := NewProxyMiddleware(newFakeSubject(), trusted, WithExcludePatterns("/public/**")).
+		Handle(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		})
+
+	r := httptest.NewRequest(http.MethodGet, "/public/index.html", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, reached)
+
+	// spoofed headers never reach handlers, not even on skipped paths
+	reached = false
+	r.Header.Set(DefaultForwardedUserHeader, "admin")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, reached)
+}
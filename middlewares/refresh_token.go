@@ -0,0 +1,505 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// RefreshToken is a long-lived token that can be exchanged for a new
+	// session, every exchange rotates it within the same family
+	RefreshToken struct {
+		// Hash is the digest of the token value
+		Hash []byte
+		// Family identifies the chain of rotated tokens
+		Family string
+		// Principal is the owner of the token
+		Principal string
+		// Platform is the platform the owner logged in
+		Platform string
+		// ExpiresAt is the time the token expires
+		ExpiresAt time.Time
+		// FamilyExpiresAt is the time the whole family expires, zero means never
+		FamilyExpiresAt time.Time
+	}
+
+	// RefreshTokenStore is responsible for persisting RefreshToken(s)
+	RefreshTokenStore interface {
+		// Save saves the specified token
+		Save(context.Context, *RefreshToken) error
+		// Find returns the token with the specified digest along with whether
+		// it has been used, without consuming it, or nil if not found
+		Find(context.Context, []byte) (*RefreshToken, bool, error)
+		// Consume marks the token with the specified digest as used, and returns it
+		// along with whether it has been used before, or nil if not found
+		Consume(context.Context, []byte) (*RefreshToken, bool, error)
+		// RevokeFamily removes all tokens belong to the specified family
+		RevokeFamily(context.Context, string) error
+	}
+
+	// MemoryRefreshTokenStore is a RefreshTokenStore backed by a map, expired
+	// tokens are swept at most once per DefaultSweepInterval
+	MemoryRefreshTokenStore struct {
+		mu      sync.Mutex
+		tokens  map[string]*memoryRefreshToken
+		sweptAt time.Time
+	}
+
+	memoryRefreshToken struct {
+		*RefreshToken
+		used bool
+	}
+
+	RefreshTokenOption func(*RefreshTokenService)
+
+	// RefreshTokenService issues and rotates RefreshToken(s), a token
+	// presented twice indicates it was stolen, in which case
+	// the whole family is revoked
+	RefreshTokenService struct {
+		store       RefreshTokenStore
+		ttl         time.Duration
+		maxLifetime time.Duration
+	}
+
+	RefreshOption func(*RefreshMiddleware)
+
+	// RefreshMiddleware serves the refresh endpoint, which exchanges
+	// a refresh token for a new session through Subject.Login
+	RefreshMiddleware struct {
+		subject        security.Subject
+		service        *RefreshTokenService
+		path           string
+		parameter      string
+		fingerprinter  Fingerprinter
+		events         *EventPublisher
+		successHandler func(http.ResponseWriter, *http.Request)
+		failureHandler func(http.ResponseWriter, *http.Request, error)
+	}
+
+	refreshTokenCtxKey struct{}
+)
+
+const (
+	DefaultRefreshPath           = "/token/refresh"
+	DefaultRefreshTokenParameter = "refresh_token"
+	DefaultRefreshTokenTTL       = 14 * 24 * time.Hour
+
+	// RefreshTokenSource is the source of PreAuthenticatedToken
+	// logged in by RefreshMiddleware
+	RefreshTokenSource = "refresh_token"
+)
+
+var (
+	_ RefreshTokenStore = (*MemoryRefreshTokenStore)(nil)
+
+	// ErrRefreshTokenExpired is returned when the refresh token expires
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// NewMemoryRefreshTokenStore returns an empty MemoryRefreshTokenStore
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: make(map[string]*memoryRefreshToken)}
+}
+
+func (s *MemoryRefreshTokenStore) Save(ctx context.Context, token *RefreshToken) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := nowFunc(); now.Sub(s.sweptAt) >= DefaultSweepInterval {
+		s.sweptAt = now
+		s.deleteExpired(now)
+	}
+	s.tokens[hex.EncodeToString(token.Hash)] = &memoryRefreshToken{RefreshToken: token}
+
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) Find(ctx context.Context, digest []byte) (*RefreshToken, bool, error) {
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hex.EncodeToString(digest)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return token.RefreshToken, token.used, nil
+}
+
+func (s *MemoryRefreshTokenStore) Consume(ctx context.Context, digest []byte) (*RefreshToken, bool, error) {
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hex.EncodeToString(digest)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	used := token.used
+	token.used = true
+
+	return token.RefreshToken, used, nil
+}
+
+func (s *MemoryRefreshTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, token := range s.tokens {
+		if token.Family == family {
+			delete(s.tokens, key)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) deleteExpired(now time.Time) {
+	for key, token := range s.tokens {
+		if !now.Before(token.ExpiresAt) {
+			delete(s.tokens, key)
+		}
+	}
+}
+
+func NewRefreshTokenService(store RefreshTokenStore, opts ...RefreshTokenOption) *RefreshTokenService {
+	s := &RefreshTokenService{store: store}
+
+	for _, f := range opts {
+		f(s)
+	}
+
+	if s.ttl <= 0 {
+		s.ttl = DefaultRefreshTokenTTL
+	}
+
+	return s
+}
+
+// Issue issues a refresh token of a new family
+func (s *RefreshTokenService) Issue(ctx context.Context, principal string, platform string) (string, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	token := &RefreshToken{
+		Family:    family,
+		Principal: principal,
+		Platform:  platform,
+	}
+	if s.maxLifetime > 0 {
+		token.FamilyExpiresAt = nowFunc().Add(s.maxLifetime)
+	}
+
+	return s.save(ctx, token)
+}
+
+// Verify returns the specified refresh token if it can be rotated, without
+// consuming it, so that a client can retry if anything fails before Rotate
+func (s *RefreshTokenService) Verify(ctx context.Context, value string) (*RefreshToken, error) {
+	token, used, err := s.store.Find(ctx, hashToken(value))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.check(ctx, token, used)
+}
+
+// Rotate consumes the specified refresh token, and issues the next one of the
+// same family, whose lifetime starts over but never exceeds the family's
+func (s *RefreshTokenService) Rotate(ctx context.Context, value string) (*RefreshToken, string, error) {
+	token, used, err := s.store.Consume(ctx, hashToken(value))
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err = s.check(ctx, token, used)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next, err := s.save(ctx, &RefreshToken{
+		Family:          token.Family,
+		Principal:       token.Principal,
+		Platform:        token.Platform,
+		FamilyExpiresAt: token.FamilyExpiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, next, nil
+}
+
+// Revoke revokes the family of the specified refresh token, e.g. on logout
+func (s *RefreshTokenService) Revoke(ctx context.Context, value string) error {
+	token, _, err := s.store.Consume(ctx, hashToken(value))
+	if err != nil || token == nil {
+		return err
+	}
+
+	return s.store.RevokeFamily(ctx, token.Family)
+}
+
+func (s *RefreshTokenService) check(ctx context.Context, token *RefreshToken, used bool) (*RefreshToken, error) {
+	if token == nil {
+		return nil, authc.ErrUnauthenticated
+	}
+
+	if used {
+		// someone else has the token, revoke them all
+		if err := s.store.RevokeFamily(ctx, token.Family); err != nil {
+			return nil, err
+		}
+		log.Printf("refresh token reused, family of %s revoked\n", token.Principal)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if !nowFunc().Before(token.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	return token, nil
+}
+
+func (s *RefreshTokenService) save(ctx context.Context, token *RefreshToken) (string, error) {
+	value, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	token.Hash = hashToken(value)
+	token.ExpiresAt = nowFunc().Add(s.ttl)
+	if !token.FamilyExpiresAt.IsZero() && token.FamilyExpiresAt.Before(token.ExpiresAt) {
+		token.ExpiresAt = token.FamilyExpiresAt
+	}
+
+	if err = s.store.Save(ctx, token); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+func hashToken(value string) []byte {
+	digest := sha256.Sum256([]byte(value))
+	return digest[:]
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contextWithRefreshToken(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, refreshTokenCtxKey{}, value)
+}
+
+// RefreshTokenFromContext returns the refresh token issued
+// along with the login or refresh request, if any
+func RefreshTokenFromContext(ctx context.Context) (string, bool) {
+	value, ok := ctx.Value(refreshTokenCtxKey{}).(string)
+	return value, ok && len(value) > 0
+}
+
+func NewRefreshMiddleware(subject security.Subject, service *RefreshTokenService, opts ...RefreshOption) *RefreshMiddleware {
+	m := &RefreshMiddleware{subject: subject, service: service}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if len(m.path) == 0 {
+		m.path = DefaultRefreshPath
+	}
+
+	if len(m.parameter) == 0 {
+		m.parameter = DefaultRefreshTokenParameter
+	}
+
+	if m.successHandler == nil {
+		m.successHandler = defaultLoginSuccessHandler(subject)
+	}
+
+	if m.failureHandler == nil {
+		m.failureHandler = defaultRefreshFailureHandler
+	}
+
+	return m
+}
+
+func (m *RefreshMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != m.path {
+			next(w, r)
+			return
+		}
+
+		m.refresh(w, r)
+	}
+}
+
+func (m *RefreshMiddleware) refresh(w http.ResponseWriter, r *http.Request) {
+	params, err := parseParameters(w, r, m.parameter)
+	if err != nil {
+		m.fail(w, r, "", err)
+		return
+	}
+
+	value := params[m.parameter]
+	if len(value) == 0 {
+		m.fail(w, r, "", ErrMalformedCredentials)
+		return
+	}
+
+	// the token is consumed only once logged in, or the retry
+	// of a failed login is taken as reuse, which revokes the family
+	token, err := m.service.Verify(r.Context(), value)
+	if err != nil {
+		m.fail(w, r, "", err)
+		return
+	}
+
+	opts := []security.LoginOption{security.WithRenewToken()}
+	if len(token.Platform) > 0 {
+		opts = append(opts, security.WithPlatform(token.Platform))
+	}
+
+	ctx, err := m.subject.Login(r.Context(), NewPreAuthenticatedToken(token.Principal, RefreshTokenSource), opts...)
+	if err != nil {
+		m.fail(w, r, token.Principal, err)
+		return
+	}
+
+	var next string
+	if err = BindSession(ctx, r, m.subject, m.fingerprinter); err == nil {
+		_, next, err = m.service.Rotate(ctx, value)
+	}
+	if err != nil {
+		// e.g. presented concurrently, drop the session just created
+		if _, lerr := m.subject.Logout(ctx); lerr != nil {
+			log.Printf("logout failed: %s\n", lerr.Error())
+		}
+		m.fail(w, r, token.Principal, err)
+		return
+	}
+
+	m.events.Publish(ctx, NewAuthEvent(r, AuthenticationSucceeded, token.Principal, RefreshMechanismName, nil))
+	m.successHandler(w, r.WithContext(contextWithRefreshToken(ctx, next)))
+}
+
+func (m *RefreshMiddleware) fail(w http.ResponseWriter, r *http.Request, principal string, err error) {
+	m.events.Publish(r.Context(), NewAuthEvent(r, AuthenticationFailed, principal, RefreshMechanismName, err))
+	m.failureHandler(w, r, err)
+}
+
+func defaultRefreshFailureHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("refresh failed: %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+
+	message := "登录已失效，请重新登录"
+	if errors.Is(err, ErrMalformedCredentials) {
+		message = "refresh token格式不正确"
+	}
+
+	writeJSON(w, http.StatusUnauthorized, struct {
+		Code    int32  `json:"code"`    // 错误码
+		Message string `json:"message"` // 错误信息
+	}{
+		Code:    http.StatusUnauthorized,
+		Message: message,
+	})
+}
+
+func WithRefreshTokenTTL(ttl time.Duration) RefreshTokenOption {
+	return func(s *RefreshTokenService) {
+		s.ttl = ttl
+	}
+}
+
+// WithRefreshTokenMaxLifetime limits the lifetime of a family, so that
+// a client has to log in again at last, zero means no limit
+func WithRefreshTokenMaxLifetime(maxLifetime time.Duration) RefreshTokenOption {
+	return func(s *RefreshTokenService) {
+		s.maxLifetime = maxLifetime
+	}
+}
+
+func WithRefreshPath(path string) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.path = path
+	}
+}
+
+func WithRefreshTokenParameter(name string) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.parameter = name
+	}
+}
+
+// WithRefreshFingerprint binds the sessions to the client that refreshed, see BindSession
+func WithRefreshFingerprint(fingerprinter Fingerprinter) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.fingerprinter = fingerprinter
+	}
+}
+
+// WithRefreshEvents publishes refresh outcomes to the specified publisher
+func WithRefreshEvents(events *EventPublisher) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.events = events
+	}
+}
+
+func WithRefreshSuccessHandler(handler func(http.ResponseWriter, *http.Request)) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.successHandler = handler
+	}
+}
+
+func WithRefreshFailureHandler(handler func(http.ResponseWriter, *http.Request, error)) RefreshOption {
+	return func(m *RefreshMiddleware) {
+		m.failureHandler = handler
+	}
+}
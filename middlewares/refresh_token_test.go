@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	realm := passwordRealm{"archer": "123"}
+	subject := newShieldSubject(realm)
+	service := NewRefreshTokenService(NewMemoryRefreshTokenStore())
+	var outcomes []string
+	events := NewEventPublisher().Subscribe(func(_ context.Context, event *AuthEvent) {
+		assert.Equal(t, RefreshMechanismName, event.Mechanism)
+		outcomes = append(outcomes, string(event.Type)+":"+event.Principal)
+	})
+	handler := NewRefreshMiddleware(subject, service, WithRefreshEvents(events)).Handle(nil)
+
+	refresh := func(value string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, DefaultRefreshPath, strings.NewReader(`{"refresh_token":"`+value+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var body struct {
+			Data struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refreshToken"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body.Data.RefreshToken
+	}
+
+	first, err := service.Issue(context.TODO(), "archer", "mobile")
+	assert.NoError(t, err)
+
+	code, second := refresh(first)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+
+	code, third := refresh(second)
+	assert.Equal(t, http.StatusOK, code)
+
+	// replaying a rotated token revokes the whole family
+	code, _ = refresh(first)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = refresh(third)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = refresh("unknown")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// a failed login does not consume the token, so it can be retried
+	fourth, err := service.Issue(context.TODO(), "saber", "mobile")
+	assert.NoError(t, err)
+	code, _ = refresh(fourth)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	realm["saber"] = "456"
+	code, fifth := refresh(fourth)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, fifth)
+
+	assert.Equal(t, []string{
+		"authentication_succeeded:archer",
+		"authentication_succeeded:archer",
+		"authentication_failed:",
+		"authentication_failed:",
+		"authentication_failed:",
+		"authentication_failed:saber",
+		"authentication_succeeded:saber",
+	}, outcomes)
+}
@@ -1,16 +1,27 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"github.com/shrinex/shield/security"
 	"github.com/shrinex/shield/semgt"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"time"
 )
 
 type (
+	SessionOption func(*SessionMiddleware)
+
 	SessionMiddleware struct {
-		subject security.Subject
+		subject         security.Subject
+		fingerprinter   Fingerprinter
+		policy          BindingPolicy
+		enabledAt       time.Time
+		mismatchHandler func(http.ResponseWriter, *http.Request, error)
 	}
 
 	sessionResponseWriter struct {
@@ -19,10 +30,33 @@ type (
 		session semgt.Session
 		written bool
 	}
+
+	fingerprintMismatchCtxKey struct{}
+)
+
+const fingerprintKey = "shield-web:fingerprint"
+
+var (
+	// ErrFingerprintMismatch is returned when the request comes from
+	// a client other than the one the session is bound to
+	ErrFingerprintMismatch = errors.New("session fingerprint mismatch")
+	// ErrReauthenticationRequired is returned when the session has
+	// been logged out because of ErrFingerprintMismatch
+	ErrReauthenticationRequired = fmt.Errorf("reauthentication required: %w", ErrFingerprintMismatch)
 )
 
-func NewSessionMiddleware(subject security.Subject) *SessionMiddleware {
-	return &SessionMiddleware{subject: subject}
+func NewSessionMiddleware(subject security.Subject, opts ...SessionOption) *SessionMiddleware {
+	m := &SessionMiddleware{subject: subject, enabledAt: nowFunc()}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.mismatchHandler == nil {
+		m.mismatchHandler = defaultUnauthorizedHandler
+	}
+
+	return m
 }
 
 func (m *SessionMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
@@ -33,6 +67,13 @@ func (m *SessionMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if m.fingerprinter != nil {
+			var ok bool
+			if r, ok = m.bind(w, r, s); !ok {
+				return
+			}
+		}
+
 		sw := &sessionResponseWriter{
 			ResponseWriter: w,
 			request:        r,
@@ -48,6 +89,62 @@ func (m *SessionMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// bind checks the fingerprint the session is bound to at login, see
+// BindSession, sessions created before binding was enabled are
+// bound on first sight, ok is false if the request is rejected
+func (m *SessionMiddleware) bind(w http.ResponseWriter, r *http.Request, s semgt.Session) (*http.Request, bool) {
+	fingerprint := digestFingerprint(m.fingerprinter(r))
+	bound, found, err := s.AttributeAsString(r.Context(), fingerprintKey)
+	if err != nil {
+		m.mismatchHandler(w, r, err)
+		return r, false
+	}
+
+	if !found {
+		firstSight, err := m.bindsOnFirstSight(r.Context(), s)
+		if err != nil {
+			m.mismatchHandler(w, r, err)
+			return r, false
+		}
+
+		if firstSight {
+			if err = s.SetAttribute(r.Context(), fingerprintKey, fingerprint); err != nil {
+				m.mismatchHandler(w, r, err)
+				return r, false
+			}
+			return r, true
+		}
+	} else if subtle.ConstantTimeCompare([]byte(bound), []byte(fingerprint)) == 1 {
+		return r, true
+	}
+
+	switch m.policy {
+	case LogMismatch:
+		log.Printf("session fingerprint mismatch: %s %s from %s\n", r.Method, r.URL.Path, RemoteIP(r))
+		return r.WithContext(context.WithValue(r.Context(), fingerprintMismatchCtxKey{}, true)), true
+	case ReauthenticateMismatch:
+		if _, err = m.subject.Logout(r.Context()); err != nil {
+			log.Printf("logout session failed: %s\n", err.Error())
+		}
+		m.mismatchHandler(w, r, ErrReauthenticationRequired)
+	default:
+		m.mismatchHandler(w, r, ErrFingerprintMismatch)
+	}
+
+	return r, false
+}
+
+// bindsOnFirstSight tells if an unbound session can be bound now, otherwise it
+// was logged in by a path not binding sessions, and taken as a mismatch
+func (m *SessionMiddleware) bindsOnFirstSight(ctx context.Context, s semgt.Session) (bool, error) {
+	startTime, err := s.StartTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return !startTime.After(m.enabledAt), nil
+}
+
 func (sw *sessionResponseWriter) Write(b []byte) (int, error) {
 	if !sw.written {
 		err := sw.session.Flush(sw.request.Context())
@@ -80,3 +177,48 @@ func detailSessionLog(r *http.Request, err error) {
 	details, _ := httputil.DumpRequest(r, true)
 	log.Printf("flush session failed: %s\n=> %+v\n", err.Error(), string(details))
 }
+
+// BindSession binds the session just logged in to the fingerprint of the
+// client, login middlewares call it right after the session is created,
+// so that it is bound to the client that presented the credentials
+// rather than to the first one presenting the token, see WithSessionBinding
+func BindSession(ctx context.Context, r *http.Request, subject security.Subject, fingerprinter Fingerprinter) error {
+	if fingerprinter == nil {
+		return nil
+	}
+
+	s, err := subject.Session(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.SetAttribute(ctx, fingerprintKey, digestFingerprint(fingerprinter(r))); err != nil {
+		return err
+	}
+
+	return s.Flush(ctx)
+}
+
+// FingerprintMismatched returns true if the request comes from a client
+// other than the one the session is bound to, see LogMismatch
+func FingerprintMismatched(ctx context.Context) bool {
+	mismatched, _ := ctx.Value(fingerprintMismatchCtxKey{}).(bool)
+	return mismatched
+}
+
+// WithSessionBinding checks sessions against the fingerprint of the client they
+// are bound to at login, and applies the specified policy to requests from
+// other clients or to sessions never bound, the login middlewares must be
+// given the same Fingerprinter, stateless identities are not bound
+func WithSessionBinding(fingerprinter Fingerprinter, policy BindingPolicy) SessionOption {
+	return func(m *SessionMiddleware) {
+		m.fingerprinter = fingerprinter
+		m.policy = policy
+	}
+}
+
+func WithFingerprintMismatchHandler(handler func(http.ResponseWriter, *http.Request, error)) SessionOption {
+	return func(m *SessionMiddleware) {
+		m.mismatchHandler = handler
+	}
+}
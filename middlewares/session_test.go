@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionBinding(t *testing.T) {
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	fingerprinter := CombineFingerprints(IPSubnetFingerprint(24, 64), UserAgentFingerprint)
+
+	var mismatched bool
+	serve := func(policy BindingPolicy, ctx context.Context, remoteAddr string, userAgent string) int {
+		handler := NewSessionMiddleware(subject, WithSessionBinding(fingerprinter, policy)).Handle(func(w http.ResponseWriter, r *http.Request) {
+			mismatched = FingerprintMismatched(r.Context())
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	login := func() context.Context {
+		ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+		assert.NoError(t, err)
+		return ctx
+	}
+
+	// bound on first sight
+	ctx := login()
+	assert.Equal(t, http.StatusTeapot, serve(RejectMismatch, ctx, "192.0.2.1:1234", "curl"))
+	assert.Equal(t, http.StatusTeapot, serve(RejectMismatch, ctx, "192.0.2.99:1234", "curl"))
+	assert.Equal(t, http.StatusUnauthorized, serve(RejectMismatch, ctx, "198.51.100.1:1234", "curl"))
+	assert.Equal(t, http.StatusUnauthorized, serve(RejectMismatch, ctx, "192.0.2.1:1234", "wget"))
+	assert.True(t, subject.Authenticated(ctx))
+
+	assert.Equal(t, http.StatusTeapot, serve(LogMismatch, ctx, "198.51.100.1:1234", "curl"))
+	assert.True(t, mismatched)
+	assert.Equal(t, http.StatusTeapot, serve(LogMismatch, ctx, "192.0.2.1:1234", "curl"))
+	assert.False(t, mismatched)
+
+	ctx = login()
+	assert.Equal(t, http.StatusTeapot, serve(ReauthenticateMismatch, ctx, "[2001:db8::1]:1234", "curl"))
+	assert.Equal(t, http.StatusTeapot, serve(ReauthenticateMismatch, ctx, "[2001:db8::2]:1234", "curl"))
+	assert.Equal(t, http.StatusUnauthorized, serve(ReauthenticateMismatch, ctx, "[2001:db9::1]:1234", "curl"))
+
+	// stateless identities are not bound
+	assert.Equal(t, http.StatusTeapot, serve(RejectMismatch, context.Background(), "198.51.100.1:1234", "curl"))
+}
+
+func TestSessionBoundAtLogin(t *testing.T) {
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	fingerprinter := IPSubnetFingerprint(24, 64)
+
+	var ctx context.Context
+	login := NewFormLoginMiddleware(subject,
+		WithLoginFingerprint(fingerprinter),
+		WithLoginSuccessHandler(func(w http.ResponseWriter, r *http.Request) {
+			ctx = r.Context()
+		}),
+	).Handle(nil)
+	handler := NewSessionMiddleware(subject, WithSessionBinding(fingerprinter, RejectMismatch)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(ctx context.Context, remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	r := httptest.NewRequest(http.MethodPost, DefaultLoginPath, strings.NewReader("username=archer&password=123"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:1234"
+	login(httptest.NewRecorder(), r)
+	assert.NotNil(t, ctx)
+
+	// a stolen token presented first by another client is still rejected
+	assert.Equal(t, http.StatusUnauthorized, serve(ctx, "198.51.100.1:1234"))
+	assert.Equal(t, http.StatusTeapot, serve(ctx, "192.0.2.1:1234"))
+
+	// sessions logged in after binding was enabled are never bound on first sight
+	ctx, err := subject.Login(context.Background(), authc.NewUsernamePasswordToken("archer", "123"), security.WithRenewToken())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, serve(ctx, "192.0.2.1:1234"))
+}
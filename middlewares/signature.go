@@ -0,0 +1,429 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// SigningKey is a secret shared with a partner who signs its requests
+	SigningKey struct {
+		// ID identifies this key, sent along with every signed request
+		ID string
+		// Principal identifies the owner of this key
+		Principal string
+		// Secret is the shared secret
+		Secret []byte
+		// Roles granted to the owner
+		Roles []authz.Role
+		// Authorities granted to the owner
+		Authorities []authz.Authority
+	}
+
+	// SigningKeyStore is responsible for loading SigningKey(s)
+	SigningKeyStore interface {
+		// Lookup returns the SigningKey with the specified id, or nil if not found
+		Lookup(context.Context, string) (*SigningKey, error)
+	}
+
+	// MemorySigningKeyStore is a SigningKeyStore backed by a map
+	MemorySigningKeyStore struct {
+		mu   sync.RWMutex
+		keys map[string]*SigningKey
+	}
+
+	// NonceStore remembers nonces of signed requests to reject replays
+	NonceStore interface {
+		// Remember records the specified nonce until it expires, and returns
+		// false if the nonce has been recorded and not expired yet
+		Remember(context.Context, string, time.Time) (bool, error)
+	}
+
+	// MemoryNonceStore is a NonceStore backed by a map, expired
+	// nonces are swept at most once per DefaultSweepInterval
+	MemoryNonceStore struct {
+		mu      sync.Mutex
+		nonces  map[string]time.Time
+		sweptAt time.Time
+	}
+
+	SignatureOption func(*signatureMechanism)
+
+	signatureMechanism struct {
+		keys          SigningKeyStore
+		nonces        NonceStore
+		signedHeaders []string
+		window        time.Duration
+		maxBody       int64
+	}
+)
+
+const (
+	// SignatureMechanismName is the name of the HMAC signature Mechanism
+	SignatureMechanismName = "hmac"
+
+	// SignatureKeyIDHeader carries the id of the SigningKey
+	SignatureKeyIDHeader = "X-Key-Id"
+	// SignatureTimestampHeader carries the unix time the request was signed
+	SignatureTimestampHeader = "X-Timestamp"
+	// SignatureNonceHeader carries a random value unique to every request
+	SignatureNonceHeader = "X-Nonce"
+	// SignatureHeader carries the base64 encoded HMAC-SHA256 signature
+	SignatureHeader = "X-Signature"
+
+	DefaultSignatureWindow  = 5 * time.Minute
+	DefaultSignatureMaxBody = 10 << 20
+)
+
+var (
+	_ SigningKeyStore = (*MemorySigningKeyStore)(nil)
+	_ NonceStore      = (*MemoryNonceStore)(nil)
+	_ Mechanism       = (*signatureMechanism)(nil)
+
+	// ErrSignatureMalformed is returned when the signature headers are incomplete or unparsable
+	ErrSignatureMalformed = fmt.Errorf("malformed signature: %w", authc.ErrInvalidToken)
+	// ErrSignatureInvalid is returned when the signature does not match, or the key is unknown
+	ErrSignatureInvalid = errors.New("signature invalid")
+	// ErrSignatureExpired is returned when the timestamp falls outside the window
+	ErrSignatureExpired = errors.New("signature expired")
+	// ErrNonceReused is returned when a signed request is replayed
+	ErrNonceReused = errors.New("nonce reused")
+)
+
+// NewMemorySigningKeyStore returns a MemorySigningKeyStore contains the specified keys
+func NewMemorySigningKeyStore(keys ...*SigningKey) *MemorySigningKeyStore {
+	s := &MemorySigningKeyStore{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+
+	return s
+}
+
+// Add adds the specified key into this store, replacing the one with the same id
+func (s *MemorySigningKeyStore) Add(key *SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+}
+
+// Remove removes the key with the specified id
+func (s *MemorySigningKeyStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, id)
+}
+
+func (s *MemorySigningKeyStore) Lookup(ctx context.Context, id string) (*SigningKey, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.keys[id], nil
+}
+
+// NewMemoryNonceStore returns an empty MemoryNonceStore
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *MemoryNonceStore) Remember(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := nowFunc()
+	if prev, ok := s.nonces[nonce]; ok && now.Before(prev) {
+		return false, nil
+	}
+
+	if now.Sub(s.sweptAt) >= DefaultSweepInterval {
+		s.sweptAt = now
+		s.deleteExpired(now)
+	}
+	s.nonces[nonce] = expiresAt
+
+	return true, nil
+}
+
+func (s *MemoryNonceStore) deleteExpired(now time.Time) {
+	for nonce, expiresAt := range s.nonces {
+		if !now.Before(expiresAt) {
+			delete(s.nonces, nonce)
+		}
+	}
+}
+
+// NewSignatureMiddleware returns an AuthcMiddleware that authenticates
+// HMAC signed requests against the specified store, replays are
+// rejected with an in-memory NonceStore
+func NewSignatureMiddleware(subject security.Subject, store SigningKeyStore, opts ...AuthcOption) *AuthcMiddleware {
+	return NewAuthcMiddleware(subject, append(opts, WithMechanism(NewSignatureMechanism(store)))...)
+}
+
+// NewSignatureMechanism returns a Mechanism that verifies the HMAC-SHA256
+// signature of the canonical request, see CanonicalRequest, the timestamp
+// must fall within the window and the nonce must not be seen before,
+// the body is buffered so that handlers can still read it, the owner
+// of the key is stored in context as Principal
+func NewSignatureMechanism(store SigningKeyStore, opts ...SignatureOption) Mechanism {
+	m := &signatureMechanism{keys: store}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.nonces == nil {
+		m.nonces = NewMemoryNonceStore()
+	}
+
+	if m.window <= 0 {
+		m.window = DefaultSignatureWindow
+	}
+
+	if m.maxBody <= 0 {
+		m.maxBody = DefaultSignatureMaxBody
+	}
+
+	return m
+}
+
+func (m *signatureMechanism) Name() string {
+	return SignatureMechanismName
+}
+
+func (m *signatureMechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	signature := r.Header.Get(SignatureHeader)
+	if len(signature) == 0 {
+		return r.Context(), ErrCredentialsNotFound
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return r.Context(), ErrSignatureMalformed
+	}
+
+	id := r.Header.Get(SignatureKeyIDHeader)
+	nonce := r.Header.Get(SignatureNonceHeader)
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	if len(id) == 0 || len(nonce) == 0 || len(timestamp) == 0 {
+		return r.Context(), ErrSignatureMalformed
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return r.Context(), ErrSignatureMalformed
+	}
+
+	// accept clock skew in both directions
+	signedAt := time.Unix(seconds, 0)
+	if now := nowFunc(); signedAt.Before(now.Add(-m.window)) || signedAt.After(now.Add(m.window)) {
+		return r.Context(), ErrSignatureExpired
+	}
+
+	body, err := bufferBody(r, m.maxBody)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	key, err := m.keys.Lookup(r.Context(), id)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	if key == nil {
+		return r.Context(), ErrSignatureInvalid
+	}
+
+	canonical := CanonicalRequest(r, body, timestamp, nonce, m.signedHeaders...)
+	if !hmac.Equal(mac, sign(key.Secret, canonical)) {
+		return r.Context(), ErrSignatureInvalid
+	}
+
+	// only remember nonces of authentic requests, the timestamp
+	// window rejects the request after the nonce expires
+	fresh, err := m.nonces.Remember(r.Context(), id+":"+nonce, signedAt.Add(m.window))
+	if err != nil {
+		return r.Context(), err
+	}
+
+	if !fresh {
+		return r.Context(), ErrNonceReused
+	}
+
+	return ContextWithPrincipal(r.Context(), &Principal{
+		Name:        key.Principal,
+		Roles:       key.Roles,
+		Authorities: key.Authorities,
+	}), nil
+}
+
+// SignRequest signs the specified request with the specified key, which is
+// what clients do, the signed headers must match the server's configuration
+func SignRequest(r *http.Request, key *SigningKey, signedHeaders ...string) error {
+	body, err := bufferBody(r, -1)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := randomString(16)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(nowFunc().Unix(), 10)
+	r.Header.Set(SignatureKeyIDHeader, key.ID)
+	r.Header.Set(SignatureTimestampHeader, timestamp)
+	r.Header.Set(SignatureNonceHeader, nonce)
+	r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(
+		sign(key.Secret, CanonicalRequest(r, body, timestamp, nonce, signedHeaders...))))
+
+	return nil
+}
+
+// CanonicalRequest returns the string to sign, which consists of
+// the following lines joined by '\n':
+//
+//	method
+//	escaped path
+//	query sorted by name then value
+//	lower-cased name:trimmed value, one line per signed header
+//	timestamp
+//	nonce
+//	hex encoded SHA-256 digest of the body
+func CanonicalRequest(r *http.Request, body []byte, timestamp string, nonce string, signedHeaders ...string) string {
+	var sb strings.Builder
+
+	sb.WriteString(r.Method)
+	sb.WriteByte('\n')
+	sb.WriteString(r.URL.EscapedPath())
+	sb.WriteByte('\n')
+	sb.WriteString(canonicalQuery(r.URL.Query()))
+	sb.WriteByte('\n')
+
+	for _, name := range signedHeaders {
+		sb.WriteString(strings.ToLower(name))
+		sb.WriteByte(':')
+		sb.WriteString(strings.TrimSpace(r.Header.Get(name)))
+		sb.WriteByte('\n')
+	}
+
+	digest := sha256.Sum256(body)
+	sb.WriteString(timestamp)
+	sb.WriteByte('\n')
+	sb.WriteString(nonce)
+	sb.WriteByte('\n')
+	sb.WriteString(hex.EncodeToString(digest[:]))
+
+	return sb.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func sign(secret []byte, canonical string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(canonical))
+	return h.Sum(nil)
+}
+
+// bufferBody reads the whole body, and replaces it with
+// an in-memory copy, a negative limit means no limit
+func bufferBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	reader := io.Reader(r.Body)
+	if limit >= 0 {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+
+	if limit >= 0 && int64(len(body)) > limit {
+		return nil, ErrSignatureMalformed
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// WithNonceStore replaces the in-memory NonceStore, which must
+// be shared across instances when running more than one
+func WithNonceStore(store NonceStore) SignatureOption {
+	return func(m *signatureMechanism) {
+		m.nonces = store
+	}
+}
+
+// WithSignedHeaders specifies the headers covered by the signature
+func WithSignedHeaders(headers ...string) SignatureOption {
+	return func(m *signatureMechanism) {
+		m.signedHeaders = append(m.signedHeaders, headers...)
+	}
+}
+
+// WithSignatureWindow specifies how far the timestamp may deviate from now
+func WithSignatureWindow(window time.Duration) SignatureOption {
+	return func(m *signatureMechanism) {
+		m.window = window
+	}
+}
+
+// WithSignatureMaxBody limits the size of the body to be buffered
+func WithSignatureMaxBody(n int64) SignatureOption {
+	return func(m *signatureMechanism) {
+		m.maxBody = n
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureAuth(t *testing.T) {
+	key := &SigningKey{ID: "partner", Principal: "acme", Secret: []byte("s3cr3t")}
+	mechanism := NewSignatureMechanism(NewMemorySigningKeyStore(key), WithSignedHeaders("Content-Type"))
+	handler := NewAuthcMiddleware(newFakeSubject(), WithMechanism(mechanism)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "acme", principal.Name)
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"event":"paid"}`, string(body))
+	})
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/hooks?b=2&a=1", strings.NewReader(`{"event":"paid"}`))
+		r.Header.Set("Content-Type", jsonContentType)
+		assert.NoError(t, SignRequest(r, key, "Content-Type"))
+		return r
+	}
+
+	r := newRequest()
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// replay
+	r.Body = io.NopCloser(strings.NewReader(`{"event":"paid"}`))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "请勿重复请求")
+
+	// tampered body
+	r = newRequest()
+	r.Body = io.NopCloser(strings.NewReader(`{"event":"refunded"}`))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "签名不正确")
+
+	// outside the window
+	defer func() { nowFunc = time.Now }()
+	r = newRequest()
+	nowFunc = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	w = httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "请求已过期")
+}
@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/codec"
+	"github.com/shrinex/shield/security"
+	"github.com/shrinex/shield/semgt"
+	"time"
+)
+
+type (
+	fakeUserCtxKey struct{}
+
+	fakeUser struct {
+		name  string
+		roles []authz.Role
+	}
+
+	// fakeSubject accepts a token if its credentials equal to
+	// the value registered under its principal
+	fakeSubject struct {
+		credentials map[string]string
+		roles       map[string][]authz.Role
+		logins      int
+	}
+)
+
+var _ security.Subject = (*fakeSubject)(nil)
+
+func newFakeSubject() *fakeSubject {
+	return &fakeSubject{
+		credentials: make(map[string]string),
+		roles:       make(map[string][]authz.Role),
+	}
+}
+
+func (u *fakeUser) Principal() string {
+	return u.name
+}
+
+func (s *fakeSubject) Authenticated(ctx context.Context) bool {
+	_, err := s.UserDetails(ctx)
+	return err == nil
+}
+
+func (s *fakeSubject) Session(context.Context) (semgt.Session, error) {
+	return nil, authc.ErrUnauthenticated
+}
+
+func (s *fakeSubject) UserDetails(ctx context.Context) (authc.UserDetails, error) {
+	user, ok := ctx.Value(fakeUserCtxKey{}).(*fakeUser)
+	if !ok || user == nil {
+		return nil, authc.ErrUnauthenticated
+	}
+	return user, nil
+}
+
+func (s *fakeSubject) HasRole(ctx context.Context, role authz.Role) bool {
+	return s.HasAnyRole(ctx, role)
+}
+
+func (s *fakeSubject) HasAnyRole(ctx context.Context, roles ...authz.Role) bool {
+	user, err := s.UserDetails(ctx)
+	if err != nil {
+		return false
+	}
+	for _, granted := range user.(*fakeUser).roles {
+		for _, role := range roles {
+			if granted.Implies(role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *fakeSubject) HasAllRole(ctx context.Context, roles ...authz.Role) bool {
+	for _, role := range roles {
+		if !s.HasRole(ctx, role) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fakeSubject) HasAuthority(context.Context, authz.Authority) bool {
+	return false
+}
+
+func (s *fakeSubject) HasAnyAuthority(context.Context, ...authz.Authority) bool {
+	return false
+}
+
+func (s *fakeSubject) HasAllAuthority(context.Context, ...authz.Authority) bool {
+	return false
+}
+
+func (s *fakeSubject) Login(ctx context.Context, token authc.Token, _ ...security.LoginOption) (context.Context, error) {
+	credentials, ok := s.credentials[token.Principal()]
+	if !ok || credentials != token.Credentials() {
+		return ctx, authc.ErrUnauthenticated
+	}
+	s.logins += 1
+	user := &fakeUser{name: token.Principal(), roles: s.roles[token.Principal()]}
+	return context.WithValue(ctx, fakeUserCtxKey{}, user), nil
+}
+
+func (s *fakeSubject) Logout(ctx context.Context) (context.Context, error) {
+	return context.WithValue(ctx, fakeUserCtxKey{}, nil), nil
+}
+
+type passwordRealm map[string]string
+
+func (r passwordRealm) Supports(token authc.Token) bool {
+	switch token.(type) {
+	case *authc.UsernamePasswordToken, *PreAuthenticatedToken:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r passwordRealm) LoadUserDetails(_ context.Context, token authc.Token) (authc.UserDetails, error) {
+	password, ok := r[token.Principal()]
+	if _, preAuthenticated := token.(*PreAuthenticatedToken); ok && preAuthenticated {
+		return &fakeUser{name: token.Principal()}, nil
+	}
+	if !ok || password != token.Credentials() {
+		return nil, authc.ErrUnauthenticated
+	}
+	return &fakeUser{name: token.Principal()}, nil
+}
+
+// newShieldSubject returns a Subject backed by in-memory sessions
+func newShieldSubject(realm passwordRealm) security.Subject {
+	repository := semgt.NewRepository(codec.JSON, time.Hour, time.Hour)
+	return security.NewBuilder[*semgt.MapSession]().
+		Authenticator(authc.NewAuthenticator(realm)).
+		Authorizer(authz.NoopAuthorizer).
+		Repository(repository).
+		Registry(semgt.NewRegistry(repository)).
+		Build()
+}
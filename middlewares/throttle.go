@@ -0,0 +1,442 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield-web/jwt"
+	"github.com/shrinex/shield/semgt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Attempts describes the failed login attempts of a key
+	Attempts struct {
+		// Failures is the number of consecutive failures
+		Failures int
+		// LastFailure is the time of the latest failure
+		LastFailure time.Time
+		// ExpiresAt is the time the failures are forgotten
+		ExpiresAt time.Time
+	}
+
+	// AttemptStore is responsible for counting failed login attempts
+	AttemptStore interface {
+		// Get returns the attempts of the specified key, or nil if none
+		Get(context.Context, string) (*Attempts, error)
+		// Fail records a failure of the specified key, the failures
+		// are forgotten after the specified ttl since the latest one
+		Fail(context.Context, string, time.Duration) (*Attempts, error)
+		// Reset forgets the failures of the specified key
+		Reset(context.Context, string) error
+	}
+
+	// MemoryAttemptStore is an AttemptStore backed by a map, expired
+	// attempts are swept at most once per DefaultSweepInterval
+	MemoryAttemptStore struct {
+		mu       sync.Mutex
+		attempts map[string]*Attempts
+		sweptAt  time.Time
+	}
+
+	// LockoutEvent is emitted once a principal or a client IP gets locked out
+	LockoutEvent struct {
+		// Principal is the locked principal, empty if the client IP is locked
+		Principal string
+		// IP is the client IP of the last failure
+		IP string
+		// Failures is the number of consecutive failures
+		Failures int
+		// Until is the time the lockout ends
+		Until time.Time
+	}
+
+	// ThrottleError is returned when a login attempt is refused without
+	// checking credentials, or when invalid credentials are presented
+	// too often, see ErrTooManyAttempts and ErrAccountLocked
+	ThrottleError struct {
+		err error
+		// RetryAfter is the time to wait before the next attempt
+		RetryAfter time.Duration
+	}
+
+	ThrottlerOption func(*Throttler)
+
+	// Throttler slows down brute-force attacks, it tracks failed login
+	// attempts per principal and per client IP, delays attempts
+	// progressively, and locks them out temporarily
+	Throttler struct {
+		store            AttemptStore
+		ttl              time.Duration
+		delayAfter       int
+		baseDelay        time.Duration
+		maxDelay         time.Duration
+		maxFailures      int
+		maxFailuresPerIP int
+		lockoutDuration  time.Duration
+		clientIP         func(*http.Request) string
+		lockoutListeners []func(context.Context, *LockoutEvent)
+	}
+)
+
+const (
+	DefaultThrottleTTL      = 15 * time.Minute
+	DefaultDelayAfter       = 3
+	DefaultBaseDelay        = time.Second
+	DefaultMaxDelay         = time.Minute
+	DefaultMaxFailures      = 5
+	DefaultMaxFailuresPerIP = 20
+	DefaultLockoutDuration  = 15 * time.Minute
+
+	principalKeyPrefix = "principal:"
+	ipKeyPrefix        = "ip:"
+)
+
+var (
+	_ AttemptStore = (*MemoryAttemptStore)(nil)
+
+	// ErrTooManyAttempts is returned when attempts are too frequent, which results in 429
+	ErrTooManyAttempts = errors.New("too many attempts")
+	// ErrAccountLocked is returned when the principal or the client IP is locked, which results in 423
+	ErrAccountLocked = errors.New("account locked")
+)
+
+// NewMemoryAttemptStore returns an empty MemoryAttemptStore
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]*Attempts)}
+}
+
+func (s *MemoryAttemptStore) Get(ctx context.Context, key string) (*Attempts, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || !nowFunc().Before(attempts.ExpiresAt) {
+		return nil, nil
+	}
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryAttemptStore) Fail(ctx context.Context, key string, ttl time.Duration) (*Attempts, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := nowFunc()
+	if now.Sub(s.sweptAt) >= DefaultSweepInterval {
+		s.sweptAt = now
+		for k, a := range s.attempts {
+			if !now.Before(a.ExpiresAt) {
+				delete(s.attempts, k)
+			}
+		}
+	}
+
+	attempts, ok := s.attempts[key]
+	if !ok || !now.Before(attempts.ExpiresAt) {
+		attempts = &Attempts{}
+		s.attempts[key] = attempts
+	}
+
+	attempts.Failures += 1
+	attempts.LastFailure = now
+	attempts.ExpiresAt = now.Add(ttl)
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.err.Error(), e.RetryAfter)
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.err
+}
+
+// NewThrottler returns a Throttler that counts failures with the specified store
+func NewThrottler(store AttemptStore, opts ...ThrottlerOption) *Throttler {
+	t := &Throttler{store: store}
+
+	for _, f := range opts {
+		f(t)
+	}
+
+	if t.ttl <= 0 {
+		t.ttl = DefaultThrottleTTL
+	}
+
+	if t.delayAfter <= 0 {
+		t.delayAfter = DefaultDelayAfter
+	}
+
+	if t.baseDelay <= 0 {
+		t.baseDelay = DefaultBaseDelay
+	}
+
+	if t.maxDelay <= 0 {
+		t.maxDelay = DefaultMaxDelay
+	}
+
+	if t.maxFailures <= 0 {
+		t.maxFailures = DefaultMaxFailures
+	}
+
+	if t.maxFailuresPerIP <= 0 {
+		t.maxFailuresPerIP = DefaultMaxFailuresPerIP
+	}
+
+	if t.lockoutDuration <= 0 {
+		t.lockoutDuration = DefaultLockoutDuration
+	}
+
+	if t.clientIP == nil {
+		t.clientIP = RemoteIP
+	}
+
+	return t
+}
+
+// ClientIP returns the client IP of the specified request
+func (t *Throttler) ClientIP(r *http.Request) string {
+	return t.clientIP(r)
+}
+
+// Check returns a *ThrottleError if the next attempt of the specified
+// principal from the specified IP must be refused, principal can be
+// empty if unknown, e.g. for bearer tokens
+func (t *Throttler) Check(ctx context.Context, principal string, ip string) error {
+	var retryAfter time.Duration
+	var locked bool
+
+	for _, key := range t.keys(principal, ip) {
+		attempts, err := t.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if attempts == nil {
+			continue
+		}
+
+		wait, lock := t.evalWait(key, attempts)
+		if wait <= 0 {
+			continue
+		}
+
+		locked = locked || lock
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	if locked {
+		return &ThrottleError{err: ErrAccountLocked, RetryAfter: retryAfter}
+	}
+
+	return &ThrottleError{err: ErrTooManyAttempts, RetryAfter: retryAfter}
+}
+
+// Failed records a failed attempt of the specified principal
+// from the specified IP, principal can be empty if unknown
+func (t *Throttler) Failed(ctx context.Context, principal string, ip string) error {
+	for _, key := range t.keys(principal, ip) {
+		attempts, err := t.store.Fail(ctx, key, t.ttl+t.lockoutDuration)
+		if err != nil {
+			return err
+		}
+
+		// notify only once, when the threshold is reached
+		if attempts.Failures != t.limitOf(key) {
+			continue
+		}
+
+		event := &LockoutEvent{
+			IP:       ip,
+			Failures: attempts.Failures,
+			Until:    attempts.LastFailure.Add(t.lockoutDuration),
+		}
+		if strings.HasPrefix(key, principalKeyPrefix) {
+			event.Principal = principal
+		}
+
+		for _, listener := range t.lockoutListeners {
+			listener(ctx, event)
+		}
+	}
+
+	return nil
+}
+
+// Succeeded forgets the failures of the specified principal, the
+// failures of the client IP are kept, otherwise an attacker could
+// reset them by logging in with their own account
+func (t *Throttler) Succeeded(ctx context.Context, principal string) error {
+	if len(principal) == 0 {
+		return nil
+	}
+
+	return t.store.Reset(ctx, principalKeyPrefix+principal)
+}
+
+func (t *Throttler) keys(principal string, ip string) []string {
+	keys := make([]string, 0, 2)
+	if len(principal) > 0 {
+		keys = append(keys, principalKeyPrefix+principal)
+	}
+
+	if len(ip) > 0 {
+		keys = append(keys, ipKeyPrefix+ip)
+	}
+
+	return keys
+}
+
+func (t *Throttler) limitOf(key string) int {
+	if strings.HasPrefix(key, ipKeyPrefix) {
+		return t.maxFailuresPerIP
+	}
+
+	return t.maxFailures
+}
+
+// evalWait returns how long the key has to wait, and whether it is locked
+func (t *Throttler) evalWait(key string, attempts *Attempts) (time.Duration, bool) {
+	now := nowFunc()
+
+	if attempts.Failures >= t.limitOf(key) {
+		return attempts.LastFailure.Add(t.lockoutDuration).Sub(now), true
+	}
+
+	if attempts.Failures < t.delayAfter {
+		return 0, false
+	}
+
+	// doubles on every failure after delayAfter
+	delay := t.baseDelay
+	for i := t.delayAfter; i < attempts.Failures && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+
+	return attempts.LastFailure.Add(delay).Sub(now), false
+}
+
+// countsAsFailure returns true if err indicates wrong credentials, rather
+// than credentials that were valid but expired or evicted
+func countsAsFailure(err error) bool {
+	var te *ThrottleError
+	return !errors.As(err, &te) &&
+		!errors.Is(err, jwt.ErrExpired) &&
+		!errors.Is(err, semgt.ErrExpired) &&
+		!errors.Is(err, semgt.ErrReplaced) &&
+		!errors.Is(err, semgt.ErrOverflow) &&
+		!isUnavailable(err)
+}
+
+// RemoteIP returns the IP of http.Request.RemoteAddr
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// evalThrottle returns the status code and the Retry-After header value of a
+// throttled attempt, ok is false if err is not caused by throttling
+func evalThrottle(err error) (code int, retryAfter string, ok bool) {
+	var te *ThrottleError
+	if !errors.As(err, &te) {
+		return 0, "", false
+	}
+
+	code = http.StatusTooManyRequests
+	if errors.Is(te, ErrAccountLocked) {
+		code = http.StatusLocked
+	}
+
+	seconds := int64((te.RetryAfter + time.Second - 1) / time.Second)
+	return code, strconv.FormatInt(seconds, 10), true
+}
+
+// WithThrottleTTL specifies how long failures are remembered since the latest one
+func WithThrottleTTL(ttl time.Duration) ThrottlerOption {
+	return func(t *Throttler) {
+		t.ttl = ttl
+	}
+}
+
+// WithProgressiveDelay delays every attempt after the specified number of failures,
+// the delay starts with base, and doubles on every further failure up to max
+func WithProgressiveDelay(after int, base time.Duration, max time.Duration) ThrottlerOption {
+	return func(t *Throttler) {
+		t.delayAfter = after
+		t.baseDelay = base
+		t.maxDelay = max
+	}
+}
+
+// WithLockout locks a principal out for the specified duration after the
+// specified number of failures, and a client IP after perIP failures
+func WithLockout(maxFailures int, perIP int, duration time.Duration) ThrottlerOption {
+	return func(t *Throttler) {
+		t.maxFailures = maxFailures
+		t.maxFailuresPerIP = perIP
+		t.lockoutDuration = duration
+	}
+}
+
+// WithClientIPFunc specifies how to determine the client IP, RemoteIP if none specified
+func WithClientIPFunc(fn func(*http.Request) string) ThrottlerOption {
+	return func(t *Throttler) {
+		t.clientIP = fn
+	}
+}
+
+// WithLockoutListener registers a listener called on every lockout
+func WithLockoutListener(listener func(context.Context, *LockoutEvent)) ThrottlerOption {
+	return func(t *Throttler) {
+		t.lockoutListeners = append(t.lockoutListeners, listener)
+	}
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/shrinex/shield/authc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottling(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	var events []*LockoutEvent
+	throttler := NewThrottler(NewMemoryAttemptStore(),
+		WithProgressiveDelay(2, time.Second, time.Minute),
+		WithLockout(4, 10, 15*time.Minute),
+		WithLockoutListener(func(_ context.Context, event *LockoutEvent) {
+			events = append(events, event)
+		}),
+	)
+	subject := newShieldSubject(passwordRealm{"archer": "123"})
+	handler := NewFormLoginMiddleware(subject, WithLoginThrottler(throttler)).Handle(nil)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, DefaultLoginPath,
+			strings.NewReader(`{"username":"archer","password":"`+password+`"}`))
+		r.Header.Set("Content-Type", jsonContentType)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("1").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("2").Code)
+
+	// delayed after 2 failures, even with the right password
+	w := login("123")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get(retryAfterHeader))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, login("3").Code)
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusUnauthorized, login("4").Code)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "archer", events[0].Principal)
+
+	w = login("123")
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Equal(t, "900", w.Header().Get(retryAfterHeader))
+
+	// the lockout ends, and a success forgets the failures
+	now = now.Add(15 * time.Minute)
+	assert.Equal(t, http.StatusOK, login("123").Code)
+	assert.NoError(t, throttler.Check(context.Background(), "archer", ""))
+}
+
+func TestAuthcThrottling(t *testing.T) {
+	subject := newFakeSubject()
+	subject.credentials["token"] = "token"
+	throttler := NewThrottler(NewMemoryAttemptStore(), WithLockout(5, 2, time.Minute))
+	handler := NewAuthcMiddleware(subject, WithThrottler(throttler)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(authorizationHeader, "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("invalid"))
+	assert.Equal(t, http.StatusLocked, serve("invalid"))
+	assert.Equal(t, http.StatusLocked, serve("invalid"))
+
+	// users sharing the IP are not locked out
+	assert.Equal(t, http.StatusTeapot, serve("token"))
+}
+
+func TestThrottlingSharedIP(t *testing.T) {
+	throttler := NewThrottler(NewMemoryAttemptStore(), WithLockout(3, 2, time.Minute))
+	form := NewFormLoginMiddleware(newShieldSubject(passwordRealm{"archer": "123", "saber": "456"}),
+		WithLoginThrottler(throttler)).Handle(nil)
+
+	login := func(username string, password string) int {
+		r := httptest.NewRequest(http.MethodPost, DefaultLoginPath,
+			strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+		r.Header.Set("Content-Type", jsonContentType)
+		w := httptest.NewRecorder()
+		form(w, r)
+		return w.Code
+	}
+
+	// the IP is locked, but not checked before the password
+	assert.Equal(t, http.StatusUnauthorized, login("archer", "1"))
+	assert.Equal(t, http.StatusUnauthorized, login("archer", "2"))
+	assert.Equal(t, http.StatusOK, login("saber", "456"))
+
+	// while a locked principal is refused before the password is tried
+	authenticator := &countingAuthenticator{Authenticator: authc.NewAuthenticator(passwordRealm{"lancer": "789"})}
+	basic := NewBasicAuthMiddleware(newFakeSubject(), "", authenticator, WithThrottler(throttler)).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("lancer", password)
+		w := httptest.NewRecorder()
+		basic(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, http.StatusTeapot, serve("wrong"))
+	}
+	assert.Equal(t, http.StatusLocked, serve("789"))
+	assert.Equal(t, 3, authenticator.calls)
+}
@@ -0,0 +1,204 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type (
+	// TokenResolver resolves the token value carried by a request
+	TokenResolver interface {
+		// Resolve returns the token value, ErrTokenNotFound if the
+		// request does not carry a token at the expected place, or
+		// authc.ErrInvalidToken if the token is present but malformed
+		Resolve(*http.Request) (string, error)
+	}
+
+	// TokenResolverFunc is an adapter to allow the use of
+	// ordinary functions as TokenResolver
+	TokenResolverFunc func(*http.Request) (string, error)
+
+	headerTokenResolver struct {
+		header string
+		scheme string
+	}
+
+	cookieTokenResolver struct {
+		name string
+	}
+
+	queryTokenResolver struct {
+		name string
+	}
+
+	formTokenResolver struct {
+		name string
+	}
+
+	compositeTokenResolver struct {
+		resolvers []TokenResolver
+	}
+)
+
+const (
+	// AccessTokenParameter is the parameter name defined by RFC 6750
+	// for tokens sent in a query string or a form-encoded body
+	AccessTokenParameter = "access_token"
+
+	formContentType = "application/x-www-form-urlencoded"
+)
+
+var (
+	_ TokenResolver = (TokenResolverFunc)(nil)
+	_ TokenResolver = (*headerTokenResolver)(nil)
+	_ TokenResolver = (*cookieTokenResolver)(nil)
+	_ TokenResolver = (*queryTokenResolver)(nil)
+	_ TokenResolver = (*formTokenResolver)(nil)
+	_ TokenResolver = (*compositeTokenResolver)(nil)
+
+	// ErrCredentialsNotFound is returned when the request does
+	// not carry the credentials a Mechanism expects
+	ErrCredentialsNotFound = fmt.Errorf("credentials not found: %w", authc.ErrInvalidToken)
+	// ErrTokenNotFound is returned when the request does not carry a token
+	ErrTokenNotFound = fmt.Errorf("token not found: %w", ErrCredentialsNotFound)
+)
+
+func (f TokenResolverFunc) Resolve(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// NewBearerTokenResolver returns a TokenResolver that reads
+// the token from the `Authorization: Bearer <token>` header
+func NewBearerTokenResolver() TokenResolver {
+	return NewHeaderTokenResolver(authorizationHeader, bearer)
+}
+
+// NewHeaderTokenResolver returns a TokenResolver that reads the token from
+// the specified header, the scheme is matched case-insensitively and
+// can be empty if the whole header value is the token
+func NewHeaderTokenResolver(header string, scheme string) TokenResolver {
+	return &headerTokenResolver{header: header, scheme: scheme}
+}
+
+func (h *headerTokenResolver) Resolve(r *http.Request) (string, error) {
+	val := r.Header.Get(h.header)
+	if len(strings.TrimSpace(val)) == 0 {
+		return "", ErrTokenNotFound
+	}
+
+	if len(h.scheme) == 0 {
+		return strings.TrimSpace(val), nil
+	}
+
+	// the scheme must be followed by at least one space
+	if len(val) <= len(h.scheme) ||
+		!strings.EqualFold(val[:len(h.scheme)], h.scheme) ||
+		val[len(h.scheme)] != ' ' {
+		return "", ErrTokenNotFound
+	}
+
+	token := strings.TrimSpace(val[len(h.scheme):])
+	if len(token) == 0 {
+		return "", authc.ErrInvalidToken
+	}
+
+	return token, nil
+}
+
+// NewCookieTokenResolver returns a TokenResolver that reads
+// the token from the cookie with the specified name
+func NewCookieTokenResolver(name string) TokenResolver {
+	return &cookieTokenResolver{name: name}
+}
+
+func (c *cookieTokenResolver) Resolve(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(c.name)
+	if err != nil {
+		return "", ErrTokenNotFound
+	}
+
+	if len(cookie.Value) == 0 {
+		return "", authc.ErrInvalidToken
+	}
+
+	return cookie.Value, nil
+}
+
+// NewQueryTokenResolver returns a TokenResolver that reads the
+// token from the query parameter with the specified name
+func NewQueryTokenResolver(name string) TokenResolver {
+	return &queryTokenResolver{name: name}
+}
+
+func (q *queryTokenResolver) Resolve(r *http.Request) (string, error) {
+	values, ok := r.URL.Query()[q.name]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+
+	if len(values) != 1 || len(values[0]) == 0 {
+		return "", authc.ErrInvalidToken
+	}
+
+	return values[0], nil
+}
+
+// NewFormTokenResolver returns a TokenResolver that reads the token from
+// the field with the specified name of a form-encoded request body,
+// note that the body will be consumed, use http.Request.PostForm to
+// access it afterwards
+func NewFormTokenResolver(name string) TokenResolver {
+	return &formTokenResolver{name: name}
+}
+
+func (f *formTokenResolver) Resolve(r *http.Request) (string, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return "", ErrTokenNotFound
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != formContentType {
+		return "", ErrTokenNotFound
+	}
+
+	if err = r.ParseForm(); err != nil {
+		return "", authc.ErrInvalidToken
+	}
+
+	values, ok := r.PostForm[f.name]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+
+	if len(values) != 1 || len(values[0]) == 0 {
+		return "", authc.ErrInvalidToken
+	}
+
+	return values[0], nil
+}
+
+// NewCompositeTokenResolver returns a TokenResolver that consults
+// the specified resolvers in order, and returns the first token found,
+// an error other than ErrTokenNotFound stops the lookup immediately
+func NewCompositeTokenResolver(resolvers ...TokenResolver) TokenResolver {
+	return &compositeTokenResolver{resolvers: resolvers}
+}
+
+func (c *compositeTokenResolver) Resolve(r *http.Request) (string, error) {
+	for _, resolver := range c.resolvers {
+		token, err := resolver.Resolve(r)
+		if err == nil {
+			return token, nil
+		}
+
+		if !errors.Is(err, ErrTokenNotFound) {
+			return "", err
+		}
+	}
+
+	return "", ErrTokenNotFound
+}
@@ -0,0 +1,117 @@
+package middlewares
+
+import (
+	"github.com/shrinex/shield/authc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBearerTokenResolver(t *testing.T) {
+	resolver := NewBearerTokenResolver()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.ErrorIs(t, err, authc.ErrInvalidToken)
+
+	r.Header.Set(authorizationHeader, "Basic dXNlcjpwYXNz")
+	_, err = resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	r.Header.Set(authorizationHeader, "Bearer ")
+	_, err = resolver.Resolve(r)
+	assert.ErrorIs(t, err, authc.ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrTokenNotFound)
+
+	r.Header.Set(authorizationHeader, "bearer abc")
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestHeaderTokenResolverWithoutScheme(t *testing.T) {
+	resolver := NewHeaderTokenResolver("X-Auth-Token", "")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Auth-Token", "  ")
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrCredentialsNotFound)
+
+	r.Header.Set("X-Auth-Token", "abc")
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestCookieTokenResolver(t *testing.T) {
+	resolver := NewCookieTokenResolver("token")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	r.AddCookie(&http.Cookie{Name: "token", Value: "abc"})
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestQueryTokenResolver(t *testing.T) {
+	resolver := NewQueryTokenResolver(AccessTokenParameter)
+
+	r := httptest.NewRequest(http.MethodGet, "/download", nil)
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	r = httptest.NewRequest(http.MethodGet, "/download?access_token=", nil)
+	_, err = resolver.Resolve(r)
+	assert.ErrorIs(t, err, authc.ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrTokenNotFound)
+
+	r = httptest.NewRequest(http.MethodGet, "/download?access_token=abc", nil)
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestFormTokenResolver(t *testing.T) {
+	resolver := NewFormTokenResolver(AccessTokenParameter)
+
+	r := httptest.NewRequest(http.MethodGet, "/?access_token=abc", nil)
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("access_token=abc&name=foo"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", token)
+	assert.Equal(t, "foo", r.PostForm.Get("name"))
+}
+
+func TestCompositeTokenResolver(t *testing.T) {
+	resolver := NewCompositeTokenResolver(
+		NewBearerTokenResolver(),
+		NewCookieTokenResolver("token"),
+		NewQueryTokenResolver(AccessTokenParameter),
+	)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := resolver.Resolve(r)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	r = httptest.NewRequest(http.MethodGet, "/?access_token=query", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "cookie"})
+	token, err := resolver.Resolve(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "cookie", token)
+
+	// malformed credentials fail fast
+	r.Header.Set(authorizationHeader, "Bearer ")
+	_, err = resolver.Resolve(r)
+	assert.ErrorIs(t, err, authc.ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrTokenNotFound)
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import "github.com/shrinex/shield/authc"
+
+type (
+	// PreAuthenticatedToken is an authc.Token whose principal has already
+	// been verified by shield-web, e.g. by a refresh token, realms that
+	// support it should load the user by principal without checking
+	// credentials, and may inspect Source to decide whether to trust it
+	PreAuthenticatedToken struct {
+		principal string
+		source    string
+	}
+)
+
+var _ authc.Token = (*PreAuthenticatedToken)(nil)
+
+// NewPreAuthenticatedToken returns a PreAuthenticatedToken for
+// the specified principal verified by the specified source
+func NewPreAuthenticatedToken(principal string, source string) authc.Token {
+	return &PreAuthenticatedToken{principal: principal, source: source}
+}
+
+func (t *PreAuthenticatedToken) Principal() string {
+	return t.principal
+}
+
+// Credentials is always empty, the principal has been verified
+func (t *PreAuthenticatedToken) Credentials() string {
+	return ""
+}
+
+// Source describes how the principal was verified, e.g. refresh_token
+func (t *PreAuthenticatedToken) Source() string {
+	return t.source
+}
@@ -0,0 +1,168 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	TOTPOption func(*TOTP)
+
+	// TOTP generates and validates time-based one-time passwords as
+	// defined by RFC 6238, with HMAC-SHA1, which is the only algorithm
+	// supported by common authenticator apps
+	TOTP struct {
+		period time.Duration
+		digits int
+		skew   int
+	}
+)
+
+const (
+	DefaultTOTPPeriod = 30 * time.Second
+	DefaultTOTPDigits = 6
+	// DefaultTOTPSkew is the number of periods accepted before
+	// and after the current one, tolerating clock drift
+	DefaultTOTPSkew = 1
+
+	totpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTP returns a TOTP with 6 digits every 30 seconds if no options specified
+func NewTOTP(opts ...TOTPOption) *TOTP {
+	t := &TOTP{skew: DefaultTOTPSkew}
+
+	for _, f := range opts {
+		f(t)
+	}
+
+	// steps are counted in whole seconds
+	if t.period < time.Second {
+		t.period = DefaultTOTPPeriod
+	}
+
+	if t.digits <= 0 || t.digits > 10 {
+		t.digits = DefaultTOTPDigits
+	}
+
+	if t.skew < 0 {
+		t.skew = 0
+	}
+
+	return t
+}
+
+// Step returns the time step of the specified time
+func (t *TOTP) Step(at time.Time) int64 {
+	return at.Unix() / int64(t.period/time.Second)
+}
+
+// Generate returns the password of the specified time
+func (t *TOTP) Generate(secret []byte, at time.Time) string {
+	return t.generate(secret, t.Step(at))
+}
+
+// Validate returns the time step the code matches, trying the steps around
+// the specified time, ok is false if none matches, the caller should
+// refuse steps not after the last accepted one to prevent replays
+func (t *TOTP) Validate(secret []byte, code string, at time.Time) (step int64, ok bool) {
+	if len(code) != t.digits {
+		return 0, false
+	}
+
+	current := t.Step(at)
+	for i := -t.skew; i <= t.skew; i++ {
+		expected := t.generate(secret, current+int64(i))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + int64(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth URI of the specified secret,
+// which is usually rendered as a QR code
+func (t *TOTP) URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(t.digits))
+	params.Set("period", fmt.Sprint(int64(t.period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// generate implements HOTP of RFC 4226 with the specified counter
+func (t *TOTP) generate(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint64(1)
+	for i := 0; i < t.digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", t.digits, uint64(value)%mod)
+}
+
+// GenerateTOTPSecret returns a random base32 encoded secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// DecodeTOTPSecret decodes a base32 encoded secret, ignoring
+// padding, spaces and case as authenticator apps do
+func DecodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+
+	decoded, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("decode totp secret: %w", err)
+	}
+
+	return decoded, nil
+}
+
+func WithTOTPPeriod(period time.Duration) TOTPOption {
+	return func(t *TOTP) {
+		t.period = period
+	}
+}
+
+func WithTOTPDigits(digits int) TOTPOption {
+	return func(t *TOTP) {
+		t.digits = digits
+	}
+}
+
+func WithTOTPSkew(skew int) TOTPOption {
+	return func(t *TOTP) {
+		t.skew = skew
+	}
+}
@@ -0,0 +1,9 @@
+package middlewares
+
+import "time"
+
+// DefaultSweepInterval is how often the memory stores sweep expired entries,
+// so that a request does not pay for scanning the whole store
+const DefaultSweepInterval = time.Minute
+
+var nowFunc = time.Now
@@ -0,0 +1,156 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/shrinex/shield/authc"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+	"strings"
+)
+
+type (
+	// X509PrincipalExtractor extracts the principal from a verified client certificate
+	X509PrincipalExtractor func(*x509.Certificate) (string, error)
+
+	// X509AuthorityMapper maps the attributes of a verified
+	// client certificate to roles and authorities
+	X509AuthorityMapper func(*x509.Certificate) ([]authz.Role, []authz.Authority)
+
+	X509Option func(*x509Mechanism)
+
+	x509Mechanism struct {
+		extractor X509PrincipalExtractor
+		mapper    X509AuthorityMapper
+	}
+)
+
+const (
+	// X509MechanismName is the name of the X.509 Mechanism
+	X509MechanismName = "x509"
+
+	// CertificateAttribute is the Principal attribute
+	// that holds the client certificate
+	CertificateAttribute = "certificate"
+)
+
+var (
+	_ Mechanism = (*x509Mechanism)(nil)
+
+	// ErrCertificateUnverified is returned when the client certificate
+	// was not verified against the server's client CAs
+	ErrCertificateUnverified = fmt.Errorf("client certificate unverified: %w", authc.ErrInvalidToken)
+	// ErrPrincipalNotFound is returned when no principal can be extracted from the certificate
+	ErrPrincipalNotFound = fmt.Errorf("principal not found in certificate: %w", authc.ErrInvalidToken)
+)
+
+// NewX509Middleware returns an AuthcMiddleware that authenticates requests
+// with the client certificate, the principal is the subject common name
+func NewX509Middleware(subject security.Subject, opts ...AuthcOption) *AuthcMiddleware {
+	return NewAuthcMiddleware(subject, append(opts, WithMechanism(NewX509Mechanism()))...)
+}
+
+// NewX509Mechanism returns a Mechanism that authenticates the client
+// certificate verified during the TLS handshake, the server has to be
+// configured with tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert,
+// the owner of the certificate is stored in context as Principal
+func NewX509Mechanism(opts ...X509Option) Mechanism {
+	m := &x509Mechanism{}
+
+	for _, f := range opts {
+		f(m)
+	}
+
+	if m.extractor == nil {
+		m.extractor = SubjectCommonName
+	}
+
+	return m
+}
+
+func (m *x509Mechanism) Name() string {
+	return X509MechanismName
+}
+
+func (m *x509Mechanism) Authenticate(r *http.Request, _ security.Subject) (context.Context, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return r.Context(), ErrCredentialsNotFound
+	}
+
+	// certificates merely requested are not trustworthy
+	if len(r.TLS.VerifiedChains) == 0 {
+		return r.Context(), ErrCertificateUnverified
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	name, err := m.extractor(cert)
+	if err != nil {
+		return r.Context(), err
+	}
+
+	if len(name) == 0 {
+		return r.Context(), ErrPrincipalNotFound
+	}
+
+	principal := &Principal{
+		Name:       name,
+		Attributes: map[string]any{CertificateAttribute: cert},
+	}
+
+	if m.mapper != nil {
+		principal.Roles, principal.Authorities = m.mapper(cert)
+	}
+
+	return ContextWithPrincipal(r.Context(), principal), nil
+}
+
+// SubjectCommonName extracts the subject common name
+func SubjectCommonName(cert *x509.Certificate) (string, error) {
+	return cert.Subject.CommonName, nil
+}
+
+// URISAN returns an X509PrincipalExtractor that extracts the first URI
+// subject alternative name with the specified prefix, e.g. spiffe://
+func URISAN(prefix string) X509PrincipalExtractor {
+	return func(cert *x509.Certificate) (string, error) {
+		for _, uri := range cert.URIs {
+			if s := uri.String(); strings.HasPrefix(s, prefix) {
+				return s, nil
+			}
+		}
+		return "", ErrPrincipalNotFound
+	}
+}
+
+// DNSSAN extracts the first DNS subject alternative name
+func DNSSAN(cert *x509.Certificate) (string, error) {
+	if len(cert.DNSNames) == 0 {
+		return "", ErrPrincipalNotFound
+	}
+
+	return cert.DNSNames[0], nil
+}
+
+// OrganizationalUnitsAsRoles maps every subject organizational unit to a role
+func OrganizationalUnitsAsRoles(cert *x509.Certificate) ([]authz.Role, []authz.Authority) {
+	roles := make([]authz.Role, 0, len(cert.Subject.OrganizationalUnit))
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		roles = append(roles, authz.NewRole(ou))
+	}
+
+	return roles, nil
+}
+
+func WithX509PrincipalExtractor(extractor X509PrincipalExtractor) X509Option {
+	return func(m *x509Mechanism) {
+		m.extractor = extractor
+	}
+}
+
+func WithX509AuthorityMapper(mapper X509AuthorityMapper) X509Option {
+	return func(m *x509Mechanism) {
+		m.mapper = mapper
+	}
+}
@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/shrinex/shield/authz"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/default/sa/orders")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "orders", OrganizationalUnit: []string{"service"}},
+		URIs:         []*url.URL{spiffe},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestX509Mechanism(t *testing.T) {
+	cert := newCertificate(t)
+	mechanism := NewX509Mechanism(
+		WithX509PrincipalExtractor(URISAN("spiffe://")),
+		WithX509AuthorityMapper(OrganizationalUnitsAsRoles),
+	)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrCredentialsNotFound)
+
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = mechanism.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrCertificateUnverified)
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	ctx, err := mechanism.Authenticate(r, nil)
+	assert.NoError(t, err)
+
+	principal, ok := PrincipalFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/orders", principal.Name)
+	assert.True(t, principal.hasRole(authz.NewRole("service")))
+}
@@ -0,0 +1,657 @@
+package pattern
+
+import (
+	"fmt"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"net/http"
+	"strings"
+)
+
+type (
+	// AttributeSource is implemented by UserDetails carrying attributes,
+	// which expressions read as principal.<name>, e.g. principal.tenant
+	AttributeSource interface {
+		// Attribute returns the named attribute, ok is false if absent
+		Attribute(string) (any, bool)
+	}
+
+	// ExpressionError reports where an expression is malformed
+	ExpressionError struct {
+		// Expression is the source being compiled
+		Expression string
+		// Offset is the byte offset the error is found at
+		Offset int
+		// Message describes the error
+		Message string
+	}
+
+	exprType int
+
+	// operand is a compiled node, whose type tells which of boolFn and strFn is set
+	operand struct {
+		typ    exprType
+		offset int
+		boolFn func(*http.Request, security.Subject) bool
+		strFn  stringFunc
+	}
+
+	// stringFunc evaluates a string, ok is false if the value is absent,
+	// e.g. a path variable the route does not capture
+	stringFunc func(*http.Request, security.Subject) (value string, ok bool)
+
+	tokenKind int
+
+	token struct {
+		kind   tokenKind
+		text   string
+		offset int
+	}
+
+	exprParser struct {
+		source string
+		tokens []token
+		pos    int
+	}
+)
+
+const (
+	boolType exprType = iota
+	stringType
+)
+
+const (
+	eofToken tokenKind = iota
+	identToken
+	stringToken
+	lparenToken
+	rparenToken
+	commaToken
+	dotToken
+	eqToken
+	neqToken
+	andToken
+	orToken
+	notToken
+)
+
+var _ error = (*ExpressionError)(nil)
+
+// CompileExpression compiles an authorization rule like
+//
+//	hasRole('admin') or (hasAuthority('orders:read') and pathVar('tenant') == principal.tenant)
+//
+// into a Predicate, the grammar is:
+//
+//	or      = and { ("or" | "||") and }
+//	and     = not { ("and" | "&&") not }
+//	not     = ("not" | "!") not | compare
+//	compare = primary [ ("==" | "!=") primary ]
+//	primary = "(" or ")" | call | string | "true" | "false" | "principal" [ "." ident ] | "method" | "path"
+//
+// the functions are authenticated(), hasRole(s), hasAnyRole(s...), hasAllRole(s...),
+// hasAuthority(s), hasAnyAuthority(s...), hasAllAuthority(s...) returning
+// bool, and pathVar(s), header(s), param(s) returning string, strings
+// are quoted by ' or " with \ escaping, principal is the principal of
+// the user, principal.<name> reads attributes via AttributeSource, both
+// == and != are false if either side is absent, like the path variable
+// a route does not capture, so that two absent values never match,
+// and functions taking an absent value return false
+func CompileExpression(expression string) (Predicate, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{source: expression, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.kind != eofToken {
+		return nil, p.errorf(next.offset, "unexpected %s", describe(next))
+	}
+
+	if root.typ != boolType {
+		return nil, p.errorf(root.offset, "expression must be a boolean, got a string")
+	}
+
+	return root.boolFn, nil
+}
+
+// MustCompileExpression is like CompileExpression but panics if malformed
+func MustCompileExpression(expression string) Predicate {
+	predicate, err := CompileExpression(expression)
+	if err != nil {
+		panic(err)
+	}
+
+	return predicate
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("invalid expression %q at column %d: %s", e.Expression, e.Offset+1, e.Message)
+}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		ch := source[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			tokens = append(tokens, token{kind: lparenToken, text: "(", offset: i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: rparenToken, text: ")", offset: i})
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{kind: commaToken, text: ",", offset: i})
+			i++
+		case ch == '.':
+			tokens = append(tokens, token{kind: dotToken, text: ".", offset: i})
+			i++
+		case strings.HasPrefix(source[i:], "=="):
+			tokens = append(tokens, token{kind: eqToken, text: "==", offset: i})
+			i += 2
+		case strings.HasPrefix(source[i:], "!="):
+			tokens = append(tokens, token{kind: neqToken, text: "!=", offset: i})
+			i += 2
+		case strings.HasPrefix(source[i:], "&&"):
+			tokens = append(tokens, token{kind: andToken, text: "&&", offset: i})
+			i += 2
+		case strings.HasPrefix(source[i:], "||"):
+			tokens = append(tokens, token{kind: orToken, text: "||", offset: i})
+			i += 2
+		case ch == '!':
+			tokens = append(tokens, token{kind: notToken, text: "!", offset: i})
+			i++
+		case ch == '\'' || ch == '"':
+			text, end, err := lexString(source, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: stringToken, text: text, offset: i})
+			i = end
+		case isIdentStart(ch):
+			start := i
+			for i < len(source) && isIdentPart(source[i]) {
+				i++
+			}
+			tokens = append(tokens, keyword(source[start:i], start))
+		default:
+			return nil, &ExpressionError{Expression: source, Offset: i, Message: fmt.Sprintf("unexpected character %q", ch)}
+		}
+	}
+
+	return append(tokens, token{kind: eofToken, offset: len(source)}), nil
+}
+
+// lexString returns the unquoted string starting at
+// start, along with the offset right after it
+func lexString(source string, start int) (string, int, error) {
+	quote := source[start]
+
+	var sb strings.Builder
+	for i := start + 1; i < len(source); i++ {
+		switch ch := source[i]; ch {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(source) {
+				break
+			}
+			i++
+			sb.WriteByte(source[i])
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+
+	return "", 0, &ExpressionError{Expression: source, Offset: start, Message: "unterminated string"}
+}
+
+// keyword turns the word operators into their symbolic tokens
+func keyword(word string, offset int) token {
+	switch word {
+	case "and":
+		return token{kind: andToken, text: word, offset: offset}
+	case "or":
+		return token{kind: orToken, text: word, offset: offset}
+	case "not":
+		return token{kind: notToken, text: word, offset: offset}
+	default:
+		return token{kind: identToken, text: word, offset: offset}
+	}
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || ('0' <= ch && ch <= '9') || ch == '-'
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case eofToken:
+		return "end of expression"
+	case stringToken:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != eofToken {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t.offset, "expected %s, got %s", what, describe(t))
+	}
+	return t, nil
+}
+
+func (p *exprParser) errorf(offset int, format string, args ...any) error {
+	return &ExpressionError{Expression: p.source, Offset: offset, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) boolean(o operand, op token) error {
+	if o.typ != boolType {
+		return p.errorf(o.offset, "operand of %q must be a boolean, got a string", op.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (operand, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+
+	for p.peek().kind == orToken {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		if err = p.boolean(left, op); err != nil {
+			return left, err
+		}
+		if err = p.boolean(right, op); err != nil {
+			return right, err
+		}
+
+		l, r := left.boolFn, right.boolFn
+		left = operand{typ: boolType, offset: left.offset, boolFn: func(req *http.Request, s security.Subject) bool {
+			return l(req, s) || r(req, s)
+		}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (operand, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+
+	for p.peek().kind == andToken {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		if err = p.boolean(left, op); err != nil {
+			return left, err
+		}
+		if err = p.boolean(right, op); err != nil {
+			return right, err
+		}
+
+		l, r := left.boolFn, right.boolFn
+		left = operand{typ: boolType, offset: left.offset, boolFn: func(req *http.Request, s security.Subject) bool {
+			return l(req, s) && r(req, s)
+		}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (operand, error) {
+	if p.peek().kind != notToken {
+		return p.parseCompare()
+	}
+
+	op := p.next()
+	o, err := p.parseNot()
+	if err != nil {
+		return o, err
+	}
+	if err = p.boolean(o, op); err != nil {
+		return o, err
+	}
+
+	f := o.boolFn
+	return operand{typ: boolType, offset: op.offset, boolFn: func(req *http.Request, s security.Subject) bool {
+		return !f(req, s)
+	}}, nil
+}
+
+func (p *exprParser) parseCompare() (operand, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return left, err
+	}
+
+	kind := p.peek().kind
+	if kind != eqToken && kind != neqToken {
+		return left, nil
+	}
+
+	op := p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return right, err
+	}
+
+	if left.typ != right.typ {
+		return right, p.errorf(op.offset, "can not compare a %s with a %s", left.typ, right.typ)
+	}
+
+	negate := op.kind == neqToken
+	if left.typ == boolType {
+		l, r := left.boolFn, right.boolFn
+		return operand{typ: boolType, offset: left.offset, boolFn: func(req *http.Request, s security.Subject) bool {
+			return (l(req, s) == r(req, s)) != negate
+		}}, nil
+	}
+
+	l, r := left.strFn, right.strFn
+	return operand{typ: boolType, offset: left.offset, boolFn: func(req *http.Request, s security.Subject) bool {
+		lv, lok := l(req, s)
+		rv, rok := r(req, s)
+		// absent values are never equal nor unequal to anything
+		if !lok || !rok {
+			return false
+		}
+		return (lv == rv) != negate
+	}}, nil
+}
+
+func (p *exprParser) parsePrimary() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case lparenToken:
+		o, err := p.parseOr()
+		if err != nil {
+			return o, err
+		}
+		if _, err = p.expect(rparenToken, "')'"); err != nil {
+			return o, err
+		}
+		o.offset = t.offset
+		return o, nil
+	case stringToken:
+		value := t.text
+		return operand{typ: stringType, offset: t.offset, strFn: func(*http.Request, security.Subject) (string, bool) {
+			return value, true
+		}}, nil
+	case identToken:
+		if p.peek().kind == lparenToken {
+			return p.parseCall(t)
+		}
+		return p.parseIdent(t)
+	default:
+		return operand{}, p.errorf(t.offset, "unexpected %s", describe(t))
+	}
+}
+
+func (p *exprParser) parseIdent(t token) (operand, error) {
+	switch t.text {
+	case "true", "false":
+		value := t.text == "true"
+		return operand{typ: boolType, offset: t.offset, boolFn: func(*http.Request, security.Subject) bool {
+			return value
+		}}, nil
+	case "method":
+		return operand{typ: stringType, offset: t.offset, strFn: func(r *http.Request, _ security.Subject) (string, bool) {
+			return r.Method, true
+		}}, nil
+	case "path":
+		return operand{typ: stringType, offset: t.offset, strFn: func(r *http.Request, _ security.Subject) (string, bool) {
+			return r.URL.Path, true
+		}}, nil
+	case "principal":
+		if p.peek().kind != dotToken {
+			return operand{typ: stringType, offset: t.offset, strFn: principalOf}, nil
+		}
+
+		p.next()
+		attr, err := p.expect(identToken, "attribute name")
+		if err != nil {
+			return operand{}, err
+		}
+
+		name := attr.text
+		return operand{typ: stringType, offset: t.offset, strFn: func(r *http.Request, s security.Subject) (string, bool) {
+			return attributeOf(r, s, name)
+		}}, nil
+	default:
+		return operand{}, p.errorf(t.offset, "unknown identifier %q", t.text)
+	}
+}
+
+func (p *exprParser) parseCall(name token) (operand, error) {
+	p.next() // (
+
+	var args []operand
+	if p.peek().kind != rparenToken {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return arg, err
+			}
+			if arg.typ != stringType {
+				return arg, p.errorf(arg.offset, "argument of %s() must be a string, got a boolean", name.text)
+			}
+			args = append(args, arg)
+
+			if p.peek().kind != commaToken {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if _, err := p.expect(rparenToken, "')'"); err != nil {
+		return operand{}, err
+	}
+
+	arity, variadic := 1, false
+	switch name.text {
+	case "authenticated":
+		arity = 0
+	case "hasAnyRole", "hasAllRole", "hasAnyAuthority", "hasAllAuthority":
+		variadic = true
+	case "hasRole", "hasAuthority", "pathVar", "header", "param":
+	default:
+		return operand{}, p.errorf(name.offset, "unknown function %s()", name.text)
+	}
+
+	if (variadic && len(args) == 0) || (!variadic && len(args) != arity) {
+		expected := fmt.Sprint(arity)
+		if variadic {
+			expected = "at least 1"
+		}
+		return operand{}, p.errorf(name.offset, "%s() expects %s argument(s), got %d", name.text, expected, len(args))
+	}
+
+	return callOf(name, args), nil
+}
+
+// callOf builds the operand of a function whose arguments have been checked
+func callOf(name token, args []operand) operand {
+	values := func(r *http.Request, s security.Subject) ([]string, bool) {
+		ss := make([]string, 0, len(args))
+		for _, arg := range args {
+			v, ok := arg.strFn(r, s)
+			if !ok {
+				return nil, false
+			}
+			ss = append(ss, v)
+		}
+		return ss, true
+	}
+	roles := func(r *http.Request, s security.Subject) ([]authz.Role, bool) {
+		vs, ok := values(r, s)
+		var rs []authz.Role
+		for _, v := range vs {
+			rs = append(rs, authz.NewRole(v))
+		}
+		return rs, ok
+	}
+	authorities := func(r *http.Request, s security.Subject) ([]authz.Authority, bool) {
+		vs, ok := values(r, s)
+		var as []authz.Authority
+		for _, v := range vs {
+			as = append(as, authz.NewAuthority(v))
+		}
+		return as, ok
+	}
+	lookup := func(fn func(*http.Request, string) (string, bool)) stringFunc {
+		return func(r *http.Request, s security.Subject) (string, bool) {
+			key, ok := args[0].strFn(r, s)
+			if !ok {
+				return "", false
+			}
+			return fn(r, key)
+		}
+	}
+
+	o := operand{typ: boolType, offset: name.offset}
+	switch name.text {
+	case "authenticated":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			return s.Authenticated(r.Context())
+		}
+	case "hasRole":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			role, ok := args[0].strFn(r, s)
+			return ok && s.HasRole(r.Context(), authz.NewRole(role))
+		}
+	case "hasAnyRole":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			rs, ok := roles(r, s)
+			return ok && s.HasAnyRole(r.Context(), rs...)
+		}
+	case "hasAllRole":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			rs, ok := roles(r, s)
+			return ok && s.HasAllRole(r.Context(), rs...)
+		}
+	case "hasAuthority":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			authority, ok := args[0].strFn(r, s)
+			return ok && s.HasAuthority(r.Context(), authz.NewAuthority(authority))
+		}
+	case "hasAnyAuthority":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			as, ok := authorities(r, s)
+			return ok && s.HasAnyAuthority(r.Context(), as...)
+		}
+	case "hasAllAuthority":
+		o.boolFn = func(r *http.Request, s security.Subject) bool {
+			as, ok := authorities(r, s)
+			return ok && s.HasAllAuthority(r.Context(), as...)
+		}
+	case "pathVar":
+		o.typ, o.strFn = stringType, lookup(func(r *http.Request, key string) (string, bool) {
+			value, ok := PathVariables(r.Context())[key]
+			return value, ok
+		})
+	case "header":
+		o.typ, o.strFn = stringType, lookup(func(r *http.Request, key string) (string, bool) {
+			values := r.Header.Values(key)
+			if len(values) == 0 {
+				return "", false
+			}
+			return values[0], true
+		})
+	case "param":
+		o.typ, o.strFn = stringType, lookup(func(r *http.Request, key string) (string, bool) {
+			values, ok := r.URL.Query()[key]
+			if !ok || len(values) == 0 {
+				return "", false
+			}
+			return values[0], true
+		})
+	}
+
+	return o
+}
+
+// principalOf returns the principal of authenticated users, absent otherwise
+func principalOf(r *http.Request, s security.Subject) (string, bool) {
+	if !s.Authenticated(r.Context()) {
+		return "", false
+	}
+
+	user, err := s.UserDetails(r.Context())
+	if err != nil {
+		return "", false
+	}
+
+	return user.Principal(), true
+}
+
+// attributeOf returns the named attribute of authenticated users
+func attributeOf(r *http.Request, s security.Subject, name string) (string, bool) {
+	if !s.Authenticated(r.Context()) {
+		return "", false
+	}
+
+	user, err := s.UserDetails(r.Context())
+	if err != nil {
+		return "", false
+	}
+
+	source, ok := user.(AttributeSource)
+	if !ok {
+		return "", false
+	}
+
+	value, ok := source.Attribute(name)
+	if !ok || value == nil {
+		return "", false
+	}
+
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+
+	return fmt.Sprint(value), true
+}
+
+func (t exprType) String() string {
+	if t == boolType {
+		return "boolean"
+	}
+
+	return "string"
+}
@@ -0,0 +1,52 @@
+package pattern
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	for _, expression := range []string{
+		"true",
+		"authenticated()",
+		"not hasRole('admin') || !hasRole(\"root\")",
+		"hasRole('admin') or (hasAuthority('orders:read') and pathVar('tenant') == principal.tenant)",
+		"hasAnyRole('a', 'b') && method != 'DELETE' && header('X-Tenant') == param('tenant')",
+		"principal == 'it\\'s me'",
+	} {
+		_, err := CompileExpression(expression)
+		assert.NoError(t, err, expression)
+	}
+
+	for expression, offset := range map[string]int{
+		"":                                  0,
+		"hasRole('admin'":                   15,
+		"hasRole('admin') and":              20,
+		"hasRole('admin') or #":             20,
+		"hasRole('admin) or true":           8,
+		"hasRole('a') and pathVar('id')":    17,
+		"hasRol('admin')":                   0,
+		"hasRole()":                         0,
+		"hasRole(true)":                     8,
+		"hasAnyRole()":                      0,
+		"pathVar('id')":                     0,
+		"pathVar('id') == true":             14,
+		"user == 'archer'":                  0,
+		"principal.":                        10,
+		"(true) (false)":                    7,
+		"hasRole('a') == principal.tenant":  13,
+		"authenticated() and not 'archer'":  24,
+		"authenticated() or (true and 'x')": 29,
+	} {
+		_, err := CompileExpression(expression)
+		var exprErr *ExpressionError
+		if assert.True(t, errors.As(err, &exprErr), expression) {
+			assert.Equal(t, offset, exprErr.Offset, "%s: %s", expression, exprErr.Error())
+		}
+	}
+
+	assert.Panics(t, func() {
+		NewRouteRegistry().AnyRequests().Access("hasRole(")
+	})
+}
@@ -1,6 +1,11 @@
 package pattern
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
 
 type (
 	// Matcher is an interface for components that can
@@ -13,12 +18,26 @@ type (
 		Matches(string, string) bool
 	}
 
+	// VariableMatcher is a Matcher that captures the variables of
+	// URI templates like /users/{id} or /users/{id:[0-9]+}
+	VariableMatcher interface {
+		Matcher
+		// ExtractVariables returns the variables captured from
+		// the given source, ok is false if it does not match
+		//
+		// pattern – the pattern to match against
+		// path – the source to match
+		ExtractVariables(string, string) (map[string]string, bool)
+	}
+
 	// Matcher implementation for Ant-style path patterns. Examples are provided below.
 	// Part of this mapping code has been kindly borrowed from Apache Ant .
 	// The mapping matches URLs using the following rules:
 	// ? matches one character
 	// * matches zero or more characters
 	// ** matches zero or more 'directories' in a path
+	// {name} matches a 'directory' or part of it, capturing it as the variable name
+	// {name:regex} matches the regex, capturing it as the variable name
 	//
 	// Some examples:
 	// com/t?st.jsp — matches com/test.jsp but also com/tast.jsp or com/txst.jsp
@@ -26,20 +45,45 @@ type (
 	// com/**/test.jsp — matches all test.jsp files underneath the com path
 	// org/springframework/**/*.jsp — matches all .jsp files underneath the org/springframework path
 	// org/**/servlet/bla.jsp — matches org/springframework/servlet/bla.jsp but also org/springframework/testing/servlet/bla.jsp and org/servlet/bla.jsp
+	// users/{id:[0-9]+}/** — matches users/1/orders, capturing id as 1
 	// NOTE: This class was borrowed from Spring Framework
 	antPathMatcher struct {
+		// templates caches the compiled segments containing variables
+		templates sync.Map
+	}
+
+	// template is a path segment containing variables compiled as regex
+	template struct {
+		regex *regexp.Regexp
+		// groups are the indexes of the capturing group of each variable
+		groups []int
+		names  []string
 	}
 )
 
 const pathSeparator = "/"
 
-var _ Matcher = (*antPathMatcher)(nil)
+var _ VariableMatcher = (*antPathMatcher)(nil)
 
 func NewMatcher() Matcher {
 	return &antPathMatcher{}
 }
 
-func (m *antPathMatcher) Matches(pattern string, path string) bool { // nolint
+func (m *antPathMatcher) Matches(pattern string, path string) bool {
+	return m.match(pattern, path, nil)
+}
+
+func (m *antPathMatcher) ExtractVariables(pattern string, path string) (map[string]string, bool) {
+	vars := make(map[string]string)
+	if !m.match(pattern, path, vars) {
+		return nil, false
+	}
+
+	return vars, true
+}
+
+// match captures variables into vars unless it is nil
+func (m *antPathMatcher) match(pattern string, path string, vars map[string]string) bool { // nolint
 	if strings.HasPrefix(pattern, pathSeparator) != strings.HasPrefix(path, pathSeparator) {
 		return false
 	}
@@ -58,7 +102,7 @@ func (m *antPathMatcher) Matches(pattern string, path string) bool { // nolint
 		if patDir == "**" {
 			break
 		}
-		if !m.matchStrings(patDir, pathDirs[pathIdxStart]) {
+		if !m.matchStrings(patDir, pathDirs[pathIdxStart], vars) {
 			return false
 		}
 		patternIdxStart++
@@ -96,7 +140,7 @@ func (m *antPathMatcher) Matches(pattern string, path string) bool { // nolint
 		if patDir == ("**") {
 			break
 		}
-		if !m.matchStrings(patDir, pathDirs[pathIdxEnd]) {
+		if !m.matchStrings(patDir, pathDirs[pathIdxEnd], vars) {
 			return false
 		}
 		patternIdxEnd--
@@ -136,7 +180,7 @@ func (m *antPathMatcher) Matches(pattern string, path string) bool { // nolint
 			for j := 0; j < patLength; j++ {
 				subPat := patternDirs[patternIdxStart+j+1]
 				subStr := pathDirs[pathIdxStart+i+j]
-				if !m.matchStrings(subPat, subStr) {
+				if !m.matchStrings(subPat, subStr, vars) {
 					continue strLoop
 				}
 			}
@@ -161,7 +205,11 @@ func (m *antPathMatcher) Matches(pattern string, path string) bool { // nolint
 	return true
 }
 
-func (m *antPathMatcher) matchStrings(pattern string, str string) bool { // nolint
+func (m *antPathMatcher) matchStrings(pattern string, str string, vars map[string]string) bool { // nolint
+	if strings.IndexByte(pattern, '{') >= 0 {
+		return m.matchTemplate(pattern, str, vars)
+	}
+
 	patArr := []byte(pattern)
 	strArr := []byte(str)
 	patIdxStart := 0
@@ -299,6 +347,107 @@ func (m *antPathMatcher) matchStrings(pattern string, str string) bool { // noli
 	return true
 }
 
+// matchTemplate matches a segment containing variables, an
+// invalid template like {id:[0-9} matches nothing
+func (m *antPathMatcher) matchTemplate(pattern string, str string, vars map[string]string) bool {
+	cached, ok := m.templates.Load(pattern)
+	if !ok {
+		// nil is cached as well, so that it fails fast next time
+		compiled, _ := compileTemplate(pattern)
+		cached, _ = m.templates.LoadOrStore(pattern, compiled)
+	}
+
+	t := cached.(*template)
+	if t == nil {
+		return false
+	}
+
+	matches := t.regex.FindStringSubmatch(str)
+	if matches == nil {
+		return false
+	}
+
+	if vars != nil {
+		for i, name := range t.names {
+			vars[name] = matches[t.groups[i]]
+		}
+	}
+
+	return true
+}
+
+// compileTemplate translates a segment like user-{id:[0-9]+}.*
+// into a regex, where ? and * keep their meanings
+func compileTemplate(pattern string) (*template, error) {
+	t := &template{}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	group := 1
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '?':
+			sb.WriteString(".")
+		case '*':
+			sb.WriteString(".*")
+		case '{':
+			end := closingBrace(pattern, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed variable in %q", pattern)
+			}
+
+			name, expr, found := strings.Cut(pattern[i+1:end], ":")
+			if !found {
+				expr = ".*"
+			}
+
+			sub, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex of variable %q: %w", name, err)
+			}
+
+			t.names = append(t.names, name)
+			t.groups = append(t.groups, group)
+			group += 1 + sub.NumSubexp()
+
+			sb.WriteString("(" + expr + ")")
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	sb.WriteString("$")
+
+	regex, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	t.regex = regex
+
+	return t, nil
+}
+
+// closingBrace returns the index of the brace closing the one at start,
+// taking nested braces like {id:[0-9]{3}} into account, or -1 if none
+func closingBrace(pattern string, start int) int {
+	depth := 0
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
 func tokenize(path, sep string) []string {
 	ss := make([]string, 0)
 	for _, s := range strings.Split(path, sep) {
@@ -2,6 +2,8 @@ package pattern
 
 import (
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -96,3 +98,48 @@ func TestSpaceInTokens(t *testing.T) {
 	assert.True(t, matcher.Matches("/group/sales/members", "/group/sales/members"))
 	assert.False(t, matcher.Matches("/group/sales/members", "/Group/  sales/Members"))
 }
+
+func TestExtractVariables(t *testing.T) {
+	extractor := matcher.(VariableMatcher)
+
+	vars, ok := extractor.ExtractVariables("/users/{id}/**", "/users/archer/orders/1")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"id": "archer"}, vars)
+
+	vars, ok = extractor.ExtractVariables("/users/{id:[0-9]+}", "/users/42")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"id": "42"}, vars)
+	assert.False(t, matcher.Matches("/users/{id:[0-9]+}", "/users/archer"))
+
+	vars, ok = extractor.ExtractVariables("/{group}/**/{name}-v{version:\\d{1,3}}.*", "/libs/a/b/shield-v12.jar")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"group": "libs", "name": "shield", "version": "12"}, vars)
+
+	// groups of the regex do not shift variables
+	vars, ok = extractor.ExtractVariables("/{kind:(a|b)(c|d)}/{id}", "/bd/1")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"kind": "bd", "id": "1"}, vars)
+
+	_, ok = extractor.ExtractVariables("/users/{id}", "/orders/1")
+	assert.False(t, ok)
+
+	// invalid templates match nothing
+	assert.False(t, matcher.Matches("/users/{id:[0-9}", "/users/1"))
+	assert.False(t, matcher.Matches("/users/{id", "/users/1"))
+}
+
+func TestMatchRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users/archer/orders", nil)
+
+	matched, ok := MatchRequest(NewRouteMatcher("/users/{id}/**", WithHTTPMethod(http.MethodGet)), r)
+	assert.True(t, ok)
+	assert.Equal(t, "archer", PathVariable(matched, "id"))
+	assert.Empty(t, PathVariable(r, "id"))
+
+	_, ok = MatchRequest(NewRouteMatcher("/users/{id}/**", WithHTTPMethod(http.MethodPost)), r)
+	assert.False(t, ok)
+
+	matched, ok = MatchRequest(NewRouteMatcher(MatchAll), r)
+	assert.True(t, ok)
+	assert.Empty(t, PathVariables(matched.Context()))
+}
@@ -0,0 +1,52 @@
+package pattern
+
+import (
+	"context"
+	"github.com/shrinex/shield/security"
+	"time"
+)
+
+// MFAVerifiedAtKey is the session attribute recording the
+// unix time the second factor was last verified
+const MFAVerifiedAtKey = "shield-web:mfa-verified-at"
+
+// nowFunc is the clock both RecordMFA and RecentMFA read
+var nowFunc = time.Now
+
+// RecordMFA records in the current session that
+// the second factor has just been verified
+func RecordMFA(ctx context.Context, subject security.Subject) error {
+	session, err := subject.Session(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = session.SetAttribute(ctx, MFAVerifiedAtKey, nowFunc().Unix()); err != nil {
+		return err
+	}
+
+	return session.Flush(ctx)
+}
+
+// MFAVerifiedAt returns the time the second factor was last
+// verified in the current session, ok is false if never
+func MFAVerifiedAt(ctx context.Context, subject security.Subject) (time.Time, bool) {
+	session, err := subject.Session(ctx)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	verifiedAt, found, err := session.AttributeAsInt(ctx, MFAVerifiedAtKey)
+	if err != nil || !found {
+		return time.Time{}, false
+	}
+
+	return time.Unix(verifiedAt, 0), true
+}
+
+// RecentMFA returns true if the second factor was verified
+// in the current session within maxAge
+func RecentMFA(ctx context.Context, subject security.Subject, maxAge time.Duration) bool {
+	verifiedAt, ok := MFAVerifiedAt(ctx, subject)
+	return ok && nowFunc().Sub(verifiedAt) <= maxAge
+}
@@ -0,0 +1,416 @@
+package pattern
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shrinex/shield/authz"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// RuleKind tells how a PolicyRule permits requests
+	RuleKind string
+
+	// Policy is the declarative form of a RouteRegistry, which can be
+	// written in YAML or JSON for review without reading Go, e.g.
+	//
+	//	rules:
+	//	  - patterns: ["GET /orders/**"]
+	//	    excludes: ["/orders/public/**"]
+	//	    kind: authority
+	//	    values: ["orders:read"]
+	//	  - patterns: ["/**"]
+	//	    kind: authenticated
+	Policy struct {
+		Rules []PolicyRule `json:"rules" yaml:"rules"`
+	}
+
+	// PolicyRule is the declarative form of a URLMapping
+	PolicyRule struct {
+		// Patterns are the routes the rule applies to, like "/orders/**"
+		// matching any method or "GET /orders/**" matching GET only
+		Patterns []string `json:"patterns" yaml:"patterns"`
+		// Excludes are the routes the rule does not apply to, in the same form as Patterns
+		Excludes []string `json:"excludes,omitempty" yaml:"excludes,omitempty"`
+		// Kind tells how the rule permits requests
+		Kind RuleKind `json:"kind" yaml:"kind"`
+		// Values are the roles, authorities, expression, max
+		// age or path variable name, depending on Kind
+		Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+	}
+
+	// PolicyError reports an invalid PolicyRule
+	PolicyError struct {
+		// Rule is the index of the rule, starting from 1
+		Rule int
+		// Field is the field that is invalid
+		Field string
+		// Message describes the error
+		Message string
+	}
+
+	// PolicyErrors reports all the invalid rules of a Policy
+	PolicyErrors []*PolicyError
+)
+
+const (
+	KindPermitAll     RuleKind = "permitAll"
+	KindDenyAll       RuleKind = "denyAll"
+	KindAuthenticated RuleKind = "authenticated"
+	KindRole          RuleKind = "role"
+	KindAnyRole       RuleKind = "anyRole"
+	KindAllRole       RuleKind = "allRole"
+	KindAuthority     RuleKind = "authority"
+	KindAnyAuthority  RuleKind = "anyAuthority"
+	KindAllAuthority  RuleKind = "allAuthority"
+	// KindExpression takes an expression, see CompileExpression
+	KindExpression RuleKind = "expression"
+	// KindRecentMFA takes a max age like 5m, see RouteRegistry.RequiresRecentMFA
+	KindRecentMFA RuleKind = "recentMFA"
+	// KindPathVariableIsPrincipal takes a path variable
+	// name, see RouteRegistry.PathVariableIsPrincipal
+	KindPathVariableIsPrincipal RuleKind = "pathVariableIsPrincipal"
+)
+
+var (
+	_ error = (*PolicyError)(nil)
+	_ error = PolicyErrors(nil)
+
+	// ErrEmptyPolicy is returned when a policy has no rules, which is
+	// refused since AuthzMiddleware permits everything without rules,
+	// e.g. a policy file read while being rewritten
+	ErrEmptyPolicy = errors.New("policy has no rules")
+
+	httpMethods = map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodPost:    true,
+		http.MethodPut:     true,
+		http.MethodPatch:   true,
+		http.MethodDelete:  true,
+		http.MethodConnect: true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+	}
+)
+
+// ReadPolicy decodes a Policy written in YAML or JSON, which is a
+// subset of YAML, unknown fields are rejected to catch typos
+func ReadPolicy(r io.Reader) (*Policy, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	var policy Policy
+	if err := decoder.Decode(&policy); err != nil {
+		if err == io.EOF {
+			return nil, ErrEmptyPolicy
+		}
+		return nil, fmt.Errorf("decode policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// LoadPolicyFile reads and validates the policy file, and
+// returns the RouteRegistry built from it
+func LoadPolicyFile(name string) (*RouteRegistry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	registry, err := LoadPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return registry, nil
+}
+
+// LoadPolicy reads and validates the policy, and
+// returns the RouteRegistry built from it
+func LoadPolicy(r io.Reader) (*RouteRegistry, error) {
+	policy, err := ReadPolicy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.Registry()
+}
+
+// Validate returns PolicyErrors listing every invalid rule,
+// or ErrEmptyPolicy if there are no rules at all
+func (p *Policy) Validate() error {
+	if len(p.Rules) == 0 {
+		return ErrEmptyPolicy
+	}
+
+	var errs PolicyErrors
+	for i, rule := range p.Rules {
+		errs = append(errs, rule.validate(i+1)...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// Registry validates the policy, and returns the RouteRegistry built from it
+func (p *Policy) Registry() (*RouteRegistry, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	registry := NewRouteRegistry()
+	for _, rule := range p.Rules {
+		rule.apply(registry)
+	}
+
+	return registry, nil
+}
+
+// WriteYAML encodes the policy as YAML
+func (p *Policy) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(p); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// WriteJSON encodes the policy as indented JSON
+func (p *Policy) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// ExportPolicy returns the declarative form of the registry, mappings
+// specified by That or by *Func methods can not be exported, neither
+// can route matchers other than the ones of NewRouteMatcher
+func ExportPolicy(registry *RouteRegistry) (*Policy, error) {
+	policy := &Policy{Rules: make([]PolicyRule, 0, len(registry.Mappings))}
+	for i, mapping := range registry.Mappings {
+		if len(mapping.Kind) == 0 {
+			return nil, fmt.Errorf("mapping %d: predicate has no declarative form", i+1)
+		}
+
+		patterns, err := exportRoutes(mapping.Includes)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i+1, err)
+		}
+
+		excludes, err := exportRoutes(mapping.Excludes)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i+1, err)
+		}
+
+		policy.Rules = append(policy.Rules, PolicyRule{
+			Patterns: patterns,
+			Excludes: excludes,
+			Kind:     mapping.Kind,
+			Values:   mapping.Args,
+		})
+	}
+
+	return policy, nil
+}
+
+func exportRoutes(matchers []RouteMatcher) ([]string, error) {
+	var routes []string
+	for _, matcher := range matchers {
+		m, ok := matcher.(*antRouteMatcher)
+		if !ok {
+			return nil, fmt.Errorf("route matcher %T has no declarative form", matcher)
+		}
+
+		if len(m.httpMethod) == 0 {
+			routes = append(routes, m.pattern)
+		} else {
+			routes = append(routes, m.httpMethod+" "+m.pattern)
+		}
+	}
+
+	return routes, nil
+}
+
+func (rule PolicyRule) validate(index int) PolicyErrors {
+	var errs PolicyErrors
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, &PolicyError{Rule: index, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(rule.Patterns) == 0 {
+		fail("patterns", "at least one pattern is required")
+	}
+	for _, route := range rule.Patterns {
+		if err := validateRoute(route); err != nil {
+			fail("patterns", "%s", err.Error())
+		}
+	}
+	for _, route := range rule.Excludes {
+		if err := validateRoute(route); err != nil {
+			fail("excludes", "%s", err.Error())
+		}
+	}
+
+	switch rule.Kind {
+	case KindPermitAll, KindDenyAll, KindAuthenticated:
+		if len(rule.Values) > 0 {
+			fail("values", "%s takes no values", rule.Kind)
+		}
+	case KindRole, KindAuthority, KindPathVariableIsPrincipal:
+		if len(rule.Values) != 1 {
+			fail("values", "%s takes exactly 1 value, got %d", rule.Kind, len(rule.Values))
+		}
+	case KindAnyRole, KindAllRole, KindAnyAuthority, KindAllAuthority:
+		if len(rule.Values) == 0 {
+			fail("values", "%s takes at least 1 value", rule.Kind)
+		}
+	case KindExpression:
+		if len(rule.Values) != 1 {
+			fail("values", "%s takes exactly 1 value, got %d", rule.Kind, len(rule.Values))
+		} else if _, err := CompileExpression(rule.Values[0]); err != nil {
+			fail("values", "%s", err.Error())
+		}
+	case KindRecentMFA:
+		if len(rule.Values) != 1 {
+			fail("values", "%s takes exactly 1 value, got %d", rule.Kind, len(rule.Values))
+		} else if maxAge, err := time.ParseDuration(rule.Values[0]); err != nil || maxAge <= 0 {
+			fail("values", "%s takes a positive duration like 5m, got %q", rule.Kind, rule.Values[0])
+		}
+	case "":
+		fail("kind", "kind is required")
+	default:
+		fail("kind", "unknown kind %q", rule.Kind)
+	}
+
+	for _, value := range rule.Values {
+		if len(strings.TrimSpace(value)) == 0 {
+			fail("values", "values must not be blank")
+			break
+		}
+	}
+
+	return errs
+}
+
+// apply adds the rule, which has been validated, to the registry
+func (rule PolicyRule) apply(registry *RouteRegistry) {
+	for _, route := range rule.Patterns {
+		method, pattern := splitRoute(route)
+		registry.RouteMatches(method, pattern)
+	}
+
+	for _, route := range rule.Excludes {
+		method, pattern := splitRoute(route)
+		registry.RouteExcludes(method, pattern)
+	}
+
+	switch rule.Kind {
+	case KindPermitAll:
+		registry.PermitAll()
+	case KindDenyAll:
+		registry.DenyAll()
+	case KindAuthenticated:
+		registry.Authenticated()
+	case KindRole:
+		registry.HasRole(authz.NewRole(rule.Values[0]))
+	case KindAnyRole:
+		registry.HasAnyRole(newRoles(rule.Values)...)
+	case KindAllRole:
+		registry.HasAllRole(newRoles(rule.Values)...)
+	case KindAuthority:
+		registry.HasAuthority(authz.NewAuthority(rule.Values[0]))
+	case KindAnyAuthority:
+		registry.HasAnyAuthority(newAuthorities(rule.Values)...)
+	case KindAllAuthority:
+		registry.HasAllAuthority(newAuthorities(rule.Values)...)
+	case KindExpression:
+		registry.Access(rule.Values[0])
+	case KindRecentMFA:
+		maxAge, _ := time.ParseDuration(rule.Values[0])
+		registry.RequiresRecentMFA(maxAge)
+	case KindPathVariableIsPrincipal:
+		registry.PathVariableIsPrincipal(rule.Values[0])
+	}
+}
+
+// splitRoute splits "GET /orders" into its method and pattern,
+// the method is empty if the route matches any method
+func splitRoute(route string) (string, string) {
+	route = strings.TrimSpace(route)
+	if method, pattern, found := strings.Cut(route, " "); found {
+		return method, strings.TrimSpace(pattern)
+	}
+
+	return "", route
+}
+
+func validateRoute(route string) error {
+	method, pattern := splitRoute(route)
+	if len(method) > 0 && !httpMethods[method] {
+		return fmt.Errorf("unknown method %q of %q", method, route)
+	}
+
+	if !strings.HasPrefix(pattern, pathSeparator) && pattern != "**" {
+		return fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	for _, segment := range tokenize(pattern, pathSeparator) {
+		if strings.IndexByte(segment, '{') < 0 {
+			continue
+		}
+		if _, err := compileTemplate(segment); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func newRoles(names []string) []authz.Role {
+	roles := make([]authz.Role, 0, len(names))
+	for _, name := range names {
+		roles = append(roles, authz.NewRole(name))
+	}
+	return roles
+}
+
+func newAuthorities(names []string) []authz.Authority {
+	authorities := make([]authz.Authority, 0, len(names))
+	for _, name := range names {
+		authorities = append(authorities, authz.NewAuthority(name))
+	}
+	return authorities
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("rule %d: %s: %s", e.Rule, e.Field, e.Message)
+}
+
+func (e PolicyErrors) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid policy: ")
+	for i, err := range e {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(err.Error())
+	}
+
+	return buf.String()
+}
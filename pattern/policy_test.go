@@ -0,0 +1,121 @@
+package pattern
+
+import (
+	"bytes"
+	"errors"
+	"github.com/shrinex/shield/authz"
+	"github.com/shrinex/shield/security"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const policyYAML = `rules:
+  - patterns:
+      - GET /orders/**
+      - POST /orders
+    excludes:
+      - /orders/public/**
+    kind: anyAuthority
+    values:
+      - orders:read
+      - orders:write
+  - patterns:
+      - /tenants/{tenant}/**
+    kind: expression
+    values:
+      - hasRole('admin') or pathVar('tenant') == principal.tenant
+  - patterns:
+      - /payouts
+    kind: recentMFA
+    values:
+      - 5m0s
+  - patterns:
+      - /**
+    kind: authenticated
+`
+
+func TestReadPolicy(t *testing.T) {
+	policy, err := ReadPolicy(strings.NewReader(policyYAML))
+	assert.NoError(t, err)
+
+	registry, err := policy.Registry()
+	assert.NoError(t, err)
+	assert.Len(t, registry.Mappings, 4)
+	assert.Len(t, registry.Mappings[0].Includes, 2)
+	assert.Len(t, registry.Mappings[0].Authorities, 2)
+	assert.Equal(t, 5*time.Minute, registry.Mappings[2].MFAMaxAge)
+
+	// round trip through both formats
+	exported, err := ExportPolicy(registry)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, exported.WriteYAML(&buf))
+	assert.Equal(t, policyYAML, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, exported.WriteJSON(&buf))
+	fromJSON, err := ReadPolicy(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, exported, fromJSON)
+
+	// typos are rejected
+	_, err = ReadPolicy(strings.NewReader("rules:\n  - patterns: [/**]\n    kinds: permitAll\n"))
+	assert.ErrorContains(t, err, "kinds")
+}
+
+func TestValidatePolicy(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Patterns: []string{"/ok"}, Kind: KindPermitAll},
+		{Patterns: []string{"FETCH /orders", "orders", "/users/{id:[0-9}"}, Kind: KindRole},
+		{Kind: "owner", Values: []string{" "}},
+		{Patterns: []string{"/a"}, Kind: KindExpression, Values: []string{"hasRole('a' and true"}},
+		{Patterns: []string{"/a"}, Kind: KindRecentMFA, Values: []string{"-1m"}},
+	}}
+
+	err := policy.Validate()
+
+	var errs PolicyErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, []string{
+		`rule 2: patterns: unknown method "FETCH" of "FETCH /orders"`,
+		`rule 2: patterns: pattern "orders" must start with /`,
+		`rule 2: patterns: pattern "/users/{id:[0-9}": invalid regex of variable "id": error parsing regexp: missing closing ]: ` + "`[0-9`",
+		`rule 2: values: role takes exactly 1 value, got 0`,
+		`rule 3: patterns: at least one pattern is required`,
+		`rule 3: kind: unknown kind "owner"`,
+		`rule 3: values: values must not be blank`,
+		`rule 4: values: invalid expression "hasRole('a' and true" at column 9: operand of "and" must be a boolean, got a string`,
+		`rule 5: values: recentMFA takes a positive duration like 5m, got "-1m"`,
+	}, messages(errs))
+
+	_, err = policy.Registry()
+	assert.Error(t, err)
+}
+
+func TestExportPolicy(t *testing.T) {
+	registry := NewRouteRegistry().
+		AntMatches("/admin/**").HasRole(authz.NewRole("admin")).
+		AnyRequests().That(func(*http.Request, security.Subject) bool { return true })
+
+	_, err := ExportPolicy(registry)
+	assert.ErrorContains(t, err, "mapping 2")
+}
+
+func messages(errs PolicyErrors) []string {
+	ss := make([]string, 0, len(errs))
+	for _, err := range errs {
+		ss = append(ss, err.Error())
+	}
+	return ss
+}
+
+func TestEmptyPolicy(t *testing.T) {
+	for _, document := range []string{"", "\n", "rules: []\n", `{"rules":[]}`} {
+		_, err := LoadPolicy(strings.NewReader(document))
+		assert.ErrorIs(t, err, ErrEmptyPolicy, document)
+	}
+}
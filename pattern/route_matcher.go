@@ -9,6 +9,14 @@ type (
 		Matches(*http.Request) bool
 	}
 
+	// VariableRouteMatcher is a RouteMatcher that captures path variables
+	VariableRouteMatcher interface {
+		RouteMatcher
+		// MatchVariables returns the captured path
+		// variables, ok is false if not matched
+		MatchVariables(*http.Request) (map[string]string, bool)
+	}
+
 	RouteMatcherOption func(*antRouteMatcher)
 
 	antRouteMatcher struct {
@@ -20,7 +28,7 @@ type (
 
 const MatchAll = "/**"
 
-var _ RouteMatcher = (*antRouteMatcher)(nil)
+var _ VariableRouteMatcher = (*antRouteMatcher)(nil)
 
 func NewRouteMatcher(pattern string, opts ...RouteMatcherOption) RouteMatcher {
 	if pattern == "**" {
@@ -51,6 +59,23 @@ func (m *antRouteMatcher) Matches(r *http.Request) bool {
 	return m.matcher.Matches(m.pattern, r.URL.Path)
 }
 
+func (m *antRouteMatcher) MatchVariables(r *http.Request) (map[string]string, bool) {
+	if len(m.httpMethod) > 0 && m.httpMethod != r.Method {
+		return nil, false
+	}
+
+	if m.pattern == MatchAll {
+		return nil, true
+	}
+
+	extractor, ok := m.matcher.(VariableMatcher)
+	if !ok {
+		return nil, m.matcher.Matches(m.pattern, r.URL.Path)
+	}
+
+	return extractor.ExtractVariables(m.pattern, r.URL.Path)
+}
+
 func WithHTTPMethod(method string) RouteMatcherOption {
 	return func(matcher *antRouteMatcher) {
 		matcher.httpMethod = method
@@ -4,6 +4,7 @@ import (
 	"github.com/shrinex/shield/authz"
 	"github.com/shrinex/shield/security"
 	"net/http"
+	"time"
 )
 
 type (
@@ -13,6 +14,15 @@ type (
 		Predicate Predicate
 		Includes  []RouteMatcher
 		Excludes  []RouteMatcher
+		// Authorities required by Predicate, if known
+		Authorities []authz.Authority
+		// MFAMaxAge is the max age of the second factor
+		// required by Predicate, zero if not required
+		MFAMaxAge time.Duration
+		// Kind and Args describe Predicate declaratively, Kind is
+		// empty if unknown, e.g. specified by That, see ExportPolicy
+		Kind RuleKind
+		Args []string
 	}
 
 	RouteRegistry struct {
@@ -72,6 +82,12 @@ func (r *RouteRegistry) That(predicate Predicate) *RouteRegistry {
 	return r
 }
 
+// Access permits requests satisfying the expression, it
+// panics if malformed, see CompileExpression
+func (r *RouteRegistry) Access(expression string) *RouteRegistry {
+	return r.That(MustCompileExpression(expression)).describe(KindExpression, expression)
+}
+
 func (r *RouteRegistry) And() *RouteRegistry {
 	return r
 }
@@ -79,25 +95,25 @@ func (r *RouteRegistry) And() *RouteRegistry {
 func (r *RouteRegistry) DenyAll() *RouteRegistry {
 	return r.That(func(*http.Request, security.Subject) bool {
 		return false
-	})
+	}).describe(KindDenyAll)
 }
 
 func (r *RouteRegistry) PermitAll() *RouteRegistry {
 	return r.That(func(*http.Request, security.Subject) bool {
 		return true
-	})
+	}).describe(KindPermitAll)
 }
 
 func (r *RouteRegistry) Authenticated() *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.Authenticated(r.Context())
-	})
+	}).describe(KindAuthenticated)
 }
 
 func (r *RouteRegistry) HasRole(role authz.Role) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasRole(r.Context(), role)
-	})
+	}).describe(KindRole, role.Desc())
 }
 
 func (r *RouteRegistry) HasRoleFunc(fn func(*http.Request, security.Subject) authz.Role) *RouteRegistry {
@@ -109,7 +125,7 @@ func (r *RouteRegistry) HasRoleFunc(fn func(*http.Request, security.Subject) aut
 func (r *RouteRegistry) HasAnyRole(roles ...authz.Role) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasAnyRole(r.Context(), roles...)
-	})
+	}).describe(KindAnyRole, roleNames(roles)...)
 }
 
 func (r *RouteRegistry) HasAnyRoleFunc(fn func(*http.Request, security.Subject) []authz.Role) *RouteRegistry {
@@ -121,7 +137,7 @@ func (r *RouteRegistry) HasAnyRoleFunc(fn func(*http.Request, security.Subject)
 func (r *RouteRegistry) HasAllRole(roles ...authz.Role) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasAllRole(r.Context(), roles...)
-	})
+	}).describe(KindAllRole, roleNames(roles)...)
 }
 
 func (r *RouteRegistry) HasAllRoleFunc(fn func(*http.Request, security.Subject) []authz.Role) *RouteRegistry {
@@ -133,7 +149,7 @@ func (r *RouteRegistry) HasAllRoleFunc(fn func(*http.Request, security.Subject)
 func (r *RouteRegistry) HasAuthority(authority authz.Authority) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasAuthority(r.Context(), authority)
-	})
+	}).requires(authority).describe(KindAuthority, authority.Desc())
 }
 
 func (r *RouteRegistry) HasAuthorityFunc(fn func(*http.Request, security.Subject) authz.Authority) *RouteRegistry {
@@ -145,7 +161,7 @@ func (r *RouteRegistry) HasAuthorityFunc(fn func(*http.Request, security.Subject
 func (r *RouteRegistry) HasAnyAuthority(authorities ...authz.Authority) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasAnyAuthority(r.Context(), authorities...)
-	})
+	}).requires(authorities...).describe(KindAnyAuthority, authorityNames(authorities)...)
 }
 
 func (r *RouteRegistry) HasAnyAuthorityFunc(fn func(*http.Request, security.Subject) []authz.Authority) *RouteRegistry {
@@ -157,7 +173,7 @@ func (r *RouteRegistry) HasAnyAuthorityFunc(fn func(*http.Request, security.Subj
 func (r *RouteRegistry) HasAllAuthority(authorities ...authz.Authority) *RouteRegistry {
 	return r.That(func(r *http.Request, subject security.Subject) bool {
 		return subject.HasAllAuthority(r.Context(), authorities...)
-	})
+	}).requires(authorities...).describe(KindAllAuthority, authorityNames(authorities)...)
 }
 
 func (r *RouteRegistry) HasAllAuthorityFunc(fn func(*http.Request, security.Subject) []authz.Authority) *RouteRegistry {
@@ -165,3 +181,62 @@ func (r *RouteRegistry) HasAllAuthorityFunc(fn func(*http.Request, security.Subj
 		return subject.HasAllAuthority(r.Context(), fn(r, subject)...)
 	})
 }
+
+// PathVariableIsPrincipal permits authenticated requests whose principal
+// equals the named path variable, e.g. the id of /users/{id}/**
+func (r *RouteRegistry) PathVariableIsPrincipal(name string) *RouteRegistry {
+	return r.That(func(r *http.Request, subject security.Subject) bool {
+		if !subject.Authenticated(r.Context()) {
+			return false
+		}
+
+		user, err := subject.UserDetails(r.Context())
+		if err != nil {
+			return false
+		}
+
+		value := PathVariable(r, name)
+		return len(value) > 0 && value == user.Principal()
+	}).describe(KindPathVariableIsPrincipal, name)
+}
+
+// RequiresRecentMFA permits authenticated requests whose session
+// verified the second factor within maxAge
+func (r *RouteRegistry) RequiresRecentMFA(maxAge time.Duration) *RouteRegistry {
+	r.That(func(r *http.Request, subject security.Subject) bool {
+		return subject.Authenticated(r.Context()) && RecentMFA(r.Context(), subject, maxAge)
+	})
+	r.Mappings[len(r.Mappings)-1].MFAMaxAge = maxAge
+	return r.describe(KindRecentMFA, maxAge.String())
+}
+
+// requires records the authorities required by the last mapping
+func (r *RouteRegistry) requires(authorities ...authz.Authority) *RouteRegistry {
+	last := &r.Mappings[len(r.Mappings)-1]
+	last.Authorities = append(last.Authorities, authorities...)
+	return r
+}
+
+// describe records the declarative form of the last mapping
+func (r *RouteRegistry) describe(kind RuleKind, args ...string) *RouteRegistry {
+	last := &r.Mappings[len(r.Mappings)-1]
+	last.Kind = kind
+	last.Args = args
+	return r
+}
+
+func roleNames(roles []authz.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Desc())
+	}
+	return names
+}
+
+func authorityNames(authorities []authz.Authority) []string {
+	names := make([]string, 0, len(authorities))
+	for _, authority := range authorities {
+		names = append(names, authority.Desc())
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package pattern
+
+import (
+	"context"
+	"net/http"
+)
+
+type pathVariablesCtxKey struct{}
+
+// MatchRequest matches the request, and returns a request whose context
+// carries the path variables captured by the matcher if any, so that
+// predicates can read them with PathVariable
+func MatchRequest(matcher RouteMatcher, r *http.Request) (*http.Request, bool) {
+	extractor, ok := matcher.(VariableRouteMatcher)
+	if !ok {
+		return r, matcher.Matches(r)
+	}
+
+	vars, ok := extractor.MatchVariables(r)
+	if !ok {
+		return r, false
+	}
+
+	if len(vars) == 0 {
+		return r, true
+	}
+
+	return r.WithContext(ContextWithPathVariables(r.Context(), vars)), true
+}
+
+// ContextWithPathVariables returns a copy of ctx carrying the variables,
+// which are merged into the ones ctx already carries
+func ContextWithPathVariables(ctx context.Context, vars map[string]string) context.Context {
+	merged := make(map[string]string, len(vars))
+	for name, value := range PathVariables(ctx) {
+		merged[name] = value
+	}
+	for name, value := range vars {
+		merged[name] = value
+	}
+
+	return context.WithValue(ctx, pathVariablesCtxKey{}, merged)
+}
+
+// PathVariables returns the path variables carried by ctx, which must not be modified
+func PathVariables(ctx context.Context) map[string]string {
+	vars, _ := ctx.Value(pathVariablesCtxKey{}).(map[string]string)
+	return vars
+}
+
+// PathVariable returns the named path variable captured
+// by the route being evaluated, empty if none
+func PathVariable(r *http.Request, name string) string {
+	return PathVariables(r.Context())[name]
+}